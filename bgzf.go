@@ -0,0 +1,75 @@
+package parallel
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"sort"
+)
+
+// BGZFBlock is an entry of a bgzip index, mapping the compressed offset of a
+// block to the uncompressed offset of its first byte.
+type BGZFBlock struct {
+	Compressed   uint64
+	Uncompressed uint64
+}
+
+// BGZFIndex lists the blocks of a bgzip (block gzip) file, ordered by offset.
+type BGZFIndex []BGZFBlock
+
+// ReadGZI reads a bgzip index in .gzi format, as written by "bgzip -i". The
+// first block, which is not stored in the file, is included.
+func ReadGZI(r io.Reader) (BGZFIndex, error) {
+	var n uint64
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	// Do not trust n for preallocation, the file may be garbled.
+	index := BGZFIndex{{}}
+	for i := uint64(0); i < n; i++ {
+		var block BGZFBlock
+		if err := binary.Read(r, binary.LittleEndian, &block); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		index = append(index, block)
+	}
+	return index, nil
+}
+
+// VirtualOffset returns the virtual offset of an uncompressed offset. The
+// upper 48 bits of a virtual offset are the compressed offset of a block, the
+// lower 16 bits the offset within the uncompressed block.
+func (idx BGZFIndex) VirtualOffset(offset uint64) uint64 {
+	i := sort.Search(len(idx), func(i int) bool {
+		return idx[i].Uncompressed > offset
+	}) - 1
+	if i < 0 {
+		return offset
+	}
+	return idx[i].Compressed<<16 | (offset - idx[i].Uncompressed)
+}
+
+// NewBGZFReader returns a reader of the uncompressed data of a bgzip file,
+// starting at the given virtual offset. Blocks are independent gzip members,
+// so readers starting at different blocks can be used in parallel, e.g. to
+// process parts of a file with separate processors. Use
+// BGZFIndex.VirtualOffset to translate an uncompressed offset.
+func NewBGZFReader(r io.ReadSeeker, voffset uint64) (io.Reader, error) {
+	if _, err := r.Seek(int64(voffset>>16), io.SeekStart); err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, zr, int64(voffset&0xffff)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return zr, nil
+}
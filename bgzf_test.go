@@ -0,0 +1,81 @@
+package parallel
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// bgzfBlock compresses b into a single bgzip block.
+func bgzfBlock(t *testing.T, b []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	// The BC subfield holds the total block size minus one, which is patched
+	// after compression.
+	zw.Header.Extra = []byte{'B', 'C', 2, 0, 0, 0}
+	if _, err := zw.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	block := buf.Bytes()
+	binary.LittleEndian.PutUint16(block[16:], uint16(len(block)-1))
+	return block
+}
+
+func TestBGZFReader(t *testing.T) {
+	var (
+		blocks = []string{"a\nb\n", "c\nd\n", "e\n"}
+		data   bytes.Buffer
+		gzi    bytes.Buffer
+		index  []BGZFBlock
+		u      uint64
+	)
+	for i, s := range blocks {
+		if i > 0 {
+			index = append(index, BGZFBlock{Compressed: uint64(data.Len()), Uncompressed: u})
+		}
+		data.Write(bgzfBlock(t, []byte(s)))
+		u += uint64(len(s))
+	}
+	_ = binary.Write(&gzi, binary.LittleEndian, uint64(len(index)))
+	_ = binary.Write(&gzi, binary.LittleEndian, index)
+	idx, err := ReadGZI(&gzi)
+	if err != nil {
+		t.Fatalf("ReadGZI: got %v, want nil", err)
+	}
+	if len(idx) != 3 {
+		t.Fatalf("ReadGZI: got %d blocks, want 3", len(idx))
+	}
+	var cases = []struct {
+		about  string
+		offset uint64
+		out    string
+	}{
+		{about: `Start of file.`, offset: 0, out: "a\nb\nc\nd\ne\n"},
+		{about: `Second block.`, offset: 4, out: "c\nd\ne\n"},
+		{about: `Inside the second block.`, offset: 6, out: "d\ne\n"},
+		{about: `Last block.`, offset: 8, out: "e\n"},
+	}
+	for _, c := range cases {
+		r, err := NewBGZFReader(bytes.NewReader(data.Bytes()), idx.VirtualOffset(c.offset))
+		if err != nil {
+			t.Fatalf("[%s] NewBGZFReader: got %v, want nil", c.about, err)
+		}
+		var buf bytes.Buffer
+		p := NewProcessor(r, &buf, ToTransformerFunc(bytes.ToUpper))
+		p.NumWorkers = 1
+		if err := p.Run(); err != nil {
+			t.Fatalf("[%s] p.Run: got %v, want nil", c.about, err)
+		}
+		if want := string(bytes.ToUpper([]byte(c.out))); buf.String() != want {
+			t.Errorf("[%s] got %q, want %q", c.about, buf.String(), want)
+		}
+	}
+	if _, err := ReadGZI(bytes.NewReader(gzi.Bytes()[:0])); err != io.EOF {
+		t.Errorf("ReadGZI: got %v, want %v", err, io.EOF)
+	}
+}
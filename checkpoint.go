@@ -0,0 +1,97 @@
+package parallel
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultCheckpointInterval = 10 * time.Second
+
+var (
+	// ErrCheckpointWorkers is returned, if checkpoints are requested with
+	// more than one worker.
+	ErrCheckpointWorkers = errors.New("checkpoints require a single worker")
+	// ErrCheckpointIdentity is returned, if checkpoints are requested with
+	// Identity.
+	ErrCheckpointIdentity = errors.New("checkpoints are not supported with Identity")
+	// ErrCheckpointDecompress is returned, if checkpoints or ResumeFrom are
+	// used with Decompress, as offsets then do not refer to R.
+	ErrCheckpointDecompress = errors.New("checkpoints and resume are not supported with Decompress")
+	// ErrCheckpointSpill is returned, if checkpoints are requested with
+	// Spill, as spilled results only reach W at the end of a run.
+	ErrCheckpointSpill = errors.New("checkpoints are not supported with Spill")
+	// ErrNotSeekable is returned by ResumeFrom, if the input is not an
+	// io.Seeker.
+	ErrNotSeekable = errors.New("resume requires a seekable input")
+)
+
+// checkpointInterval returns the interval between checkpoints.
+func (p *Processor) checkpointInterval() time.Duration {
+	if p.CheckpointInterval <= 0 {
+		return defaultCheckpointInterval
+	}
+	return p.CheckpointInterval
+}
+
+// checkpoint flushes the sink and then records offset in CheckpointFile. The
+// file is replaced atomically, so it always contains a complete offset.
+func (p *Processor) checkpoint(s sink, offset int64) error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	tmp := p.CheckpointFile + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(offset, 10)+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.CheckpointFile)
+}
+
+// ReadCheckpoint returns the offset stored in a checkpoint file.
+func ReadCheckpoint(filename string) (int64, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// ResumeFrom seeks the input to offset and skips to the next record boundary,
+// so processing starts with the first complete record at or after offset. An
+// offset taken from a checkpoint is already at a record boundary. Offsets
+// reported to OffsetF and written to checkpoints stay relative to the start of
+// the input. Records are found using RecordSeparator, even if a SplitFunc is
+// set. Call ResumeFrom before Run.
+func (p *Processor) ResumeFrom(offset int64) error {
+	if p.Decompress {
+		return ErrCheckpointDecompress
+	}
+	rs, ok := p.R.(io.Seeker)
+	if !ok {
+		return ErrNotSeekable
+	}
+	if offset <= 0 {
+		_, err := rs.Seek(0, io.SeekStart)
+		p.resumeOffset = 0
+		return err
+	}
+	// Start at the byte before offset, which is a separator, if offset is
+	// at a record boundary.
+	if _, err := rs.Seek(offset-1, io.SeekStart); err != nil {
+		return err
+	}
+	b, err := bufio.NewReader(p.R).ReadBytes(p.RecordSeparator)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	// The buffered reader may have read past the boundary, so R is
+	// positioned again, instead of being replaced by the buffered reader,
+	// so that calling ResumeFrom again starts over.
+	p.resumeOffset = offset - 1 + int64(len(b))
+	_, err = rs.Seek(p.resumeOffset, io.SeekStart)
+	return err
+}
@@ -0,0 +1,220 @@
+package parallel
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	var input bytes.Buffer
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&input, "%d\n", i)
+	}
+	var (
+		filename = filepath.Join(t.TempDir(), "checkpoint")
+		first    bytes.Buffer
+		second   bytes.Buffer
+	)
+	// The first run is interrupted by an output limit.
+	p := NewProcessor(bytes.NewReader(input.Bytes()), &first, ToTransformerFunc(bytes.ToUpper))
+	p.NumWorkers = 1
+	p.BatchSize = 5
+	p.MaxOutputBytes = 50
+	p.CheckpointFile = filename
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	offset, err := ReadCheckpoint(filename)
+	if err != nil {
+		t.Fatalf("ReadCheckpoint: got %v, want nil", err)
+	}
+	if offset != int64(first.Len()) {
+		t.Fatalf("ReadCheckpoint: got %d, want %d", offset, first.Len())
+	}
+	p = NewProcessor(bytes.NewReader(input.Bytes()), &second, ToTransformerFunc(bytes.ToUpper))
+	p.NumWorkers = 1
+	p.CheckpointFile = filename
+	if err := p.ResumeFrom(offset); err != nil {
+		t.Fatalf("p.ResumeFrom: got %v, want nil", err)
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if got := first.String() + second.String(); got != input.String() {
+		t.Errorf("got %q, want %q", got, input.String())
+	}
+	offset, err = ReadCheckpoint(filename)
+	if err != nil {
+		t.Fatalf("ReadCheckpoint: got %v, want nil", err)
+	}
+	if offset != int64(input.Len()) {
+		t.Errorf("ReadCheckpoint: got %d, want %d", offset, input.Len())
+	}
+}
+
+func TestResumeFrom(t *testing.T) {
+	var cases = []struct {
+		about  string
+		offset int64
+		out    string
+	}{
+		{about: `Start of input.`, offset: 0, out: "a\nbb\nccc\n"},
+		{about: `Record boundary.`, offset: 2, out: "bb\nccc\n"},
+		{about: `Inside a record.`, offset: 3, out: "ccc\n"},
+		{about: `End of input.`, offset: 9, out: ""},
+	}
+	for _, c := range cases {
+		var (
+			buf bytes.Buffer
+			r   = strings.NewReader("a\nbb\nccc\n")
+		)
+		p := NewProcessor(r, &buf, func(b []byte) ([]byte, error) {
+			return b, nil
+		})
+		p.NumWorkers = 1
+		// Calling ResumeFrom again must not skip any further.
+		for i := 0; i < 2; i++ {
+			if err := p.ResumeFrom(c.offset); err != nil {
+				t.Fatalf("[%s] p.ResumeFrom: got %v, want nil", c.about, err)
+			}
+		}
+		if p.R != r {
+			t.Errorf("[%s] p.R: got %v, want original reader", c.about, p.R)
+		}
+		if err := p.Run(); err != nil {
+			t.Fatalf("[%s] p.Run: got %v, want nil", c.about, err)
+		}
+		if buf.String() != c.out {
+			t.Errorf("[%s] got %q, want %q", c.about, buf.String(), c.out)
+		}
+	}
+	p := NewProcessor(&countingReader{r: strings.NewReader("a\n")}, &bytes.Buffer{}, nil)
+	if err := p.ResumeFrom(1); err != ErrNotSeekable {
+		t.Errorf("p.ResumeFrom: got %v, want %v", err, ErrNotSeekable)
+	}
+	p.CheckpointFile = filepath.Join(t.TempDir(), "checkpoint")
+	p.NumWorkers = 2
+	if err := p.Run(); err != ErrCheckpointWorkers {
+		t.Errorf("p.Run: got %v, want %v", err, ErrCheckpointWorkers)
+	}
+}
+
+func TestCheckpointUnsupported(t *testing.T) {
+	var cases = []struct {
+		about      string
+		identity   bool
+		decompress bool
+		spill      bool
+		resume     int64
+		checkpoint bool
+		err        error
+	}{
+		{about: `Checkpoints with Identity.`, identity: true, checkpoint: true, err: ErrCheckpointIdentity},
+		{about: `Checkpoints with Decompress.`, decompress: true, checkpoint: true, err: ErrCheckpointDecompress},
+		{about: `Resume with Decompress.`, decompress: true, resume: 2, err: ErrCheckpointDecompress},
+		{about: `Checkpoints with Spill.`, spill: true, checkpoint: true, err: ErrCheckpointSpill},
+		{about: `Resume with Identity.`, identity: true, resume: 2, err: nil},
+	}
+	for _, c := range cases {
+		p := NewProcessor(strings.NewReader("a\nb\n"), io.Discard, ToTransformerFunc(bytes.ToUpper))
+		p.NumWorkers = 1
+		p.Identity = c.identity
+		if c.spill {
+			p.Spill = &SpillSink{Dir: t.TempDir()}
+		}
+		if c.checkpoint {
+			p.CheckpointFile = filepath.Join(t.TempDir(), "checkpoint")
+		}
+		if err := p.ResumeFrom(c.resume); err != nil {
+			t.Fatalf("[%s] p.ResumeFrom: got %v, want nil", c.about, err)
+		}
+		// Decompress is set after ResumeFrom, so Run must catch it.
+		p.Decompress = c.decompress
+		if err := p.Run(); err != c.err {
+			t.Errorf("[%s] p.Run: got %v, want %v", c.about, err, c.err)
+		}
+	}
+	p := NewProcessor(strings.NewReader("a\nb\n"), io.Discard, nil)
+	p.Decompress = true
+	if err := p.ResumeFrom(2); err != ErrCheckpointDecompress {
+		t.Errorf("p.ResumeFrom: got %v, want %v", err, ErrCheckpointDecompress)
+	}
+}
+
+func TestCheckpointFlushesDeadLetters(t *testing.T) {
+	var (
+		input    bytes.Buffer
+		filename = filepath.Join(t.TempDir(), "checkpoint")
+		dead     syncBuffer
+		missing  atomic.Int64
+	)
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&input, "%d\n", i)
+	}
+	p := NewProcessor(bytes.NewReader(input.Bytes()), io.Discard, func(b []byte) ([]byte, error) {
+		time.Sleep(time.Millisecond)
+		// Every record fails, so the dead letters must cover the input up
+		// to any checkpoint written.
+		if offset, err := ReadCheckpoint(filename); err == nil && int64(len(dead.String())) < offset {
+			missing.Add(1)
+		}
+		return nil, errors.New("failed")
+	})
+	p.NumWorkers = 1
+	p.BatchSize = 1
+	p.CheckpointFile = filename
+	p.CheckpointInterval = time.Millisecond
+	p.DeadLetterWriter = &dead
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if n := missing.Load(); n > 0 {
+		t.Errorf("got %d checkpoints ahead of dead letters, want 0", n)
+	}
+	if dead.String() != input.String() {
+		t.Errorf("got %q, want %q", dead.String(), input.String())
+	}
+}
+
+func TestCheckpointFlushesAux(t *testing.T) {
+	var (
+		input    bytes.Buffer
+		filename = filepath.Join(t.TempDir(), "checkpoint")
+		aux      syncBuffer
+		missing  atomic.Int64
+	)
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&input, "%d\n", i)
+	}
+	p := NewProcessor(bytes.NewReader(input.Bytes()), io.Discard, nil)
+	p.AuxF = func(b []byte) ([]byte, []byte, error) {
+		time.Sleep(100 * time.Microsecond)
+		// Every record is copied to the auxiliary output, which must cover
+		// the input up to any checkpoint written.
+		if offset, err := ReadCheckpoint(filename); err == nil && int64(len(aux.String())) < offset {
+			missing.Add(1)
+		}
+		return b, b, nil
+	}
+	p.NumWorkers = 1
+	p.BatchSize = 1
+	p.CheckpointFile = filename
+	p.CheckpointInterval = 100 * time.Microsecond
+	p.AuxWriter = &aux
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if n := missing.Load(); n > 0 {
+		t.Errorf("got %d checkpoints ahead of auxiliary output, want 0", n)
+	}
+	if aux.String() != input.String() {
+		t.Errorf("got %q, want %q", aux.String(), input.String())
+	}
+}
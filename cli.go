@@ -0,0 +1,76 @@
+package parallel
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Main is a helper for command line tools, which transform files or standard
+// input. It parses the following flags from args, usually os.Args[1:]:
+//
+//	-w workers     number of workers, defaults to the number of CPUs
+//	-b size        batch size, defaults to 10000
+//	-o filename    output file, defaults to standard output
+//
+// All remaining arguments are input files, processed one after another; "-"
+// or no argument at all means standard input. A usage message is printed for
+// -h, and flag.ErrHelp returned.
+func Main(args []string, f TransformerFunc) error {
+	return runMain(args, os.Stdin, os.Stdout, f)
+}
+
+// runMain implements Main with configurable standard input and output.
+func runMain(args []string, stdin io.Reader, stdout io.Writer, f TransformerFunc) (err error) {
+	defaults := NewProcessor(nil, nil, nil)
+	fs := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
+	var (
+		numWorkers = fs.Int("w", defaults.NumWorkers, "number of workers")
+		batchSize  = fs.Int("b", defaults.BatchSize, "batch size")
+		output     = fs.String("o", "", "output file, defaults to stdout")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	w := stdout
+	if *output != "" && *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := file.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		w = file
+	}
+	inputs := fs.Args()
+	if len(inputs) == 0 {
+		inputs = []string{"-"}
+	}
+	for _, name := range inputs {
+		if err := runInput(name, stdin, w, f, *numWorkers, *batchSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runInput processes a single input file, or stdin, if name is "-".
+func runInput(name string, stdin io.Reader, w io.Writer, f TransformerFunc, numWorkers, batchSize int) error {
+	r := stdin
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	p := NewProcessor(r, w, f)
+	p.NumWorkers = numWorkers
+	p.BatchSize = batchSize
+	return p.Run()
+}
@@ -0,0 +1,68 @@
+package parallel
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMainArgs(t *testing.T) {
+	dir := t.TempDir()
+	var (
+		a      = filepath.Join(dir, "a.txt")
+		b      = filepath.Join(dir, "b.txt")
+		output = filepath.Join(dir, "out.txt")
+	)
+	if err := os.WriteFile(a, []byte("a\nb"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, []byte("c\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var cases = []struct {
+		about  string
+		args   []string
+		stdin  string
+		stdout string
+		output string
+		err    error
+	}{
+		{about: `Standard input.`, stdin: "x\ny\n", stdout: "X\nY\n"},
+		{about: `Dash is standard input.`, args: []string{"-w", "2", "-"}, stdin: "x\n", stdout: "X\n"},
+		{about: `Files.`, args: []string{"-b", "1", a, b}, stdout: "A\nB\nC\n"},
+		{about: `Files and stdin.`, args: []string{a, "-"}, stdin: "x\n", stdout: "A\nB\nX\n"},
+		{about: `Output file.`, args: []string{"-o", output, b}, output: "C\n"},
+		{about: `Missing file.`, args: []string{filepath.Join(dir, "missing")}, err: os.ErrNotExist},
+		{about: `Help.`, args: []string{"-h"}, err: flag.ErrHelp},
+		{about: `Invalid workers.`, args: []string{"-w", "0"}, err: ErrInvalidWorkers},
+	}
+	for _, c := range cases {
+		var stdout bytes.Buffer
+		err := runMain(c.args, strings.NewReader(c.stdin), &stdout, ToTransformerFunc(bytes.ToUpper))
+		if c.err != nil {
+			if !errors.Is(err, c.err) {
+				t.Errorf("[%s] got %v, want %v", c.about, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("[%s] got %v, want nil", c.about, err)
+		}
+		if !LinesEqual(stdout.String(), c.stdout) {
+			t.Errorf("[%s] got %q, want %q", c.about, stdout.String(), c.stdout)
+		}
+		if c.output != "" {
+			b, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatalf("[%s] got %v, want nil", c.about, err)
+			}
+			if string(b) != c.output {
+				t.Errorf("[%s] got %q, want %q", c.about, b, c.output)
+			}
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package parallel
+
+import "encoding/json"
+
+// Codec converts between records and values of type T.
+type Codec[T any] interface {
+	Decode(b []byte) (T, error)
+	Encode(v T) ([]byte, error)
+}
+
+// JSONCodec is a codec for newline delimited JSON. Encoded values end with a
+// newline.
+type JSONCodec[T any] struct{}
+
+// Decode parses a JSON document.
+func (JSONCodec[T]) Decode(b []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(b, &v)
+	return v, err
+}
+
+// Encode serializes v as JSON, followed by a newline.
+func (JSONCodec[T]) Encode(v T) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// CodecTransformer returns a transformer, that decodes each record with c,
+// passes the value to f and encodes the result with c, so f can work with
+// structured values instead of bytes. Decoding and encoding errors are
+// returned like errors of f.
+func CodecTransformer[T any](c Codec[T], f func(T) (T, error)) TransformerFunc {
+	return func(b []byte) ([]byte, error) {
+		v, err := c.Decode(b)
+		if err != nil {
+			return nil, err
+		}
+		if v, err = f(v); err != nil {
+			return nil, err
+		}
+		return c.Encode(v)
+	}
+}
@@ -0,0 +1,57 @@
+package parallel
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCodecTransformer(t *testing.T) {
+	type book struct {
+		Title string `json:"title"`
+		Year  int    `json:"year"`
+	}
+	f := CodecTransformer[book](JSONCodec[book]{}, func(b book) (book, error) {
+		b.Title = strings.ToUpper(b.Title)
+		b.Year++
+		return b, nil
+	})
+	var cases = []struct {
+		about  string
+		input  string
+		result string
+		err    bool
+	}{
+		{
+			about:  `Struct round trip.`,
+			input:  "{\"title\": \"dune\", \"year\": 1964}\n{\"title\": \"emma\", \"year\": 1814}\n",
+			result: "{\"title\":\"DUNE\",\"year\":1965}\n{\"title\":\"EMMA\",\"year\":1815}\n",
+		},
+		{
+			about: `Invalid JSON.`,
+			input: "{\"title\": \n",
+			err:   true,
+		},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		p := NewProcessor(strings.NewReader(c.input), &buf, f)
+		p.NumWorkers = 1
+		err := p.Run()
+		if c.err {
+			var syntaxErr *json.SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Errorf("[%s] got %v, want a *json.SyntaxError", c.about, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("[%s] p.Run: got %v, want nil", c.about, err)
+		}
+		if buf.String() != c.result {
+			t.Errorf("[%s] got %q, want %q", c.about, buf.String(), c.result)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package parallel
+
+import (
+	"bytes"
+	"compress/gzip"
+)
+
+// defaultBlockPrefixSize is the length prefix size for compressed batches.
+const defaultBlockPrefixSize = 4
+
+// lengthPrefixSize returns the size of the length prefix of frames, or zero,
+// if results are not framed.
+func (p *Processor) lengthPrefixSize() int {
+	if p.CompressBatches && p.LengthPrefixSize == 0 {
+		return defaultBlockPrefixSize
+	}
+	return p.LengthPrefixSize
+}
+
+// compressResults returns a single result with the gzip compressed results
+// of a batch. The block is empty, if all results are empty, so nothing is
+// written for the batch.
+func compressResults(rs []result) (result, error) {
+	r := result{end: rs[len(rs)-1].end}
+	var (
+		buf bytes.Buffer
+		zw  = gzip.NewWriter(&buf)
+		n   int
+	)
+	for _, x := range rs {
+		if len(x.b) == 0 {
+			continue
+		}
+		if _, err := zw.Write(x.b); err != nil {
+			return r, err
+		}
+		n++
+	}
+	if n == 0 {
+		return r, nil
+	}
+	if err := zw.Close(); err != nil {
+		return r, err
+	}
+	r.b = buf.Bytes()
+	return r, nil
+}
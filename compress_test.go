@@ -0,0 +1,79 @@
+package parallel
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/miku/parallel/record"
+)
+
+func TestCompressBatches(t *testing.T) {
+	var cases = []struct {
+		about      string
+		prefixSize int
+		numBlocks  int
+	}{
+		{about: `Default prefix size.`, prefixSize: 0, numBlocks: 4},
+		{about: `Eight byte prefix.`, prefixSize: 8, numBlocks: 4},
+	}
+	for _, c := range cases {
+		var (
+			input bytes.Buffer
+			lines []string
+		)
+		for i := 0; i < 10; i++ {
+			fmt.Fprintf(&input, "{\"id\":%d}\n", i)
+			lines = append(lines, fmt.Sprintf("{\"ID\":%d}", i))
+		}
+		var buf bytes.Buffer
+		p := NewProcessor(&input, &buf, ToTransformerFunc(bytes.ToUpper))
+		p.BatchSize = 3
+		p.CompressBatches = true
+		p.LengthPrefixSize = c.prefixSize
+		if err := p.Run(); err != nil {
+			t.Fatalf("[%s] p.Run: got %v, want nil", c.about, err)
+		}
+		size := c.prefixSize
+		if size == 0 {
+			size = 4
+		}
+		scanner := bufio.NewScanner(&buf)
+		scanner.Split(record.NewLengthPrefixedSplitter(binary.BigEndian, size))
+		var (
+			result    []string
+			numBlocks int
+		)
+		for scanner.Scan() {
+			// Each block decompresses on its own.
+			zr, err := gzip.NewReader(bytes.NewReader(scanner.Bytes()))
+			if err != nil {
+				t.Fatalf("[%s] gzip: got %v, want nil", c.about, err)
+			}
+			b, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatalf("[%s] gzip: got %v, want nil", c.about, err)
+			}
+			result = append(result, strings.Fields(string(b))...)
+			numBlocks++
+		}
+		if err := scanner.Err(); err != nil {
+			t.Fatalf("[%s] scanner: got %v, want nil", c.about, err)
+		}
+		sort.Strings(result)
+		sort.Strings(lines)
+		if !reflect.DeepEqual(result, lines) {
+			t.Errorf("[%s] got %v, want %v", c.about, result, lines)
+		}
+		if numBlocks != c.numBlocks {
+			t.Errorf("[%s] got %d blocks, want %d", c.about, numBlocks, c.numBlocks)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package parallel
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"sync"
+)
+
+// Magic bytes of common compression formats.
+const (
+	MagicGzip  = "\x1f\x8b"
+	MagicBzip2 = "BZh"
+	MagicXZ    = "\xfd7zXZ\x00"
+	MagicZstd  = "\x28\xb5\x2f\xfd"
+)
+
+// ErrUnsupportedCompression is returned, if the input is compressed in a
+// known format, for which no decompressor has been registered.
+var ErrUnsupportedCompression = errors.New("no decompressor registered for compressed input")
+
+// Decompressor returns a reader of the decompressed data of r.
+type Decompressor func(r io.Reader) (io.Reader, error)
+
+var (
+	decompressorsMu sync.RWMutex
+	// decompressors maps magic bytes to decompressors, a nil decompressor
+	// marks a known, but unsupported format.
+	decompressors = map[string]Decompressor{
+		MagicGzip: newGzipReader,
+		MagicBzip2: func(r io.Reader) (io.Reader, error) {
+			return bzip2.NewReader(r), nil
+		},
+		MagicXZ:   nil,
+		MagicZstd: nil,
+	}
+)
+
+// RegisterDecompressor registers a decompressor for inputs starting with
+// magic, e.g. for xz or zstd, which are not supported by the standard
+// library, so the dependency stays optional:
+//
+//	parallel.RegisterDecompressor(parallel.MagicXZ, func(r io.Reader) (io.Reader, error) {
+//		return xz.NewReader(r)
+//	})
+//
+// A previously registered decompressor for the same magic is replaced.
+func RegisterDecompressor(magic string, d Decompressor) {
+	decompressorsMu.Lock()
+	defer decompressorsMu.Unlock()
+	decompressors[magic] = d
+}
+
+// NewDecompressReader detects the compression format of r by its magic bytes
+// and returns a reader of the decompressed data. Gzip and bzip2 are supported
+// out of the box, other formats can be added with RegisterDecompressor.
+// Uncompressed input is returned as it is. Gzip input may consist of
+// multiple members and may be followed by plain data, which is then read as
+// it is, so the reader presents a single stream.
+func NewDecompressReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	decompressorsMu.RLock()
+	var (
+		magic string
+		d     Decompressor
+	)
+	for m, f := range decompressors {
+		if len(m) <= len(magic) {
+			continue
+		}
+		if b, _ := br.Peek(len(m)); bytes.Equal(b, []byte(m)) {
+			magic, d = m, f
+		}
+	}
+	decompressorsMu.RUnlock()
+	switch {
+	case magic == "":
+		return br, nil
+	case d == nil:
+		return nil, ErrUnsupportedCompression
+	}
+	return d(br)
+}
+
+// decompressReader detects the compression format on the first read, so
+// errors are reported by Read.
+type decompressReader struct {
+	r        io.Reader
+	detected bool
+	err      error
+}
+
+func (d *decompressReader) Read(p []byte) (int, error) {
+	if !d.detected {
+		d.detected = true
+		d.r, d.err = NewDecompressReader(d.r)
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return d.r.Read(p)
+}
+
+// gzipReader decompresses concatenated gzip members, like gzip.Reader, but
+// continues with plain data, if the last member is followed by anything but
+// another member, e.g. a plain text trailer.
+type gzipReader struct {
+	br    *bufio.Reader
+	zr    *gzip.Reader
+	plain bool
+}
+
+// newGzipReader returns a reader for gzip members, optionally followed by
+// plain data.
+func newGzipReader(r io.Reader) (io.Reader, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	// With an io.ByteReader, gzip does not read past the end of a member.
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	zr.Multistream(false)
+	return &gzipReader{br: br, zr: zr}, nil
+}
+
+func (g *gzipReader) Read(p []byte) (int, error) {
+	if g.plain {
+		return g.br.Read(p)
+	}
+	n, err := g.zr.Read(p)
+	if err != io.EOF {
+		return n, err
+	}
+	// End of a member, look at what follows.
+	b, err := g.br.Peek(len(MagicGzip))
+	switch {
+	case bytes.Equal(b, []byte(MagicGzip)):
+		if err := g.zr.Reset(g.br); err != nil {
+			return n, err
+		}
+		g.zr.Multistream(false)
+	case len(b) == 0 && err == io.EOF:
+		return n, io.EOF
+	case len(b) == 0:
+		return n, err
+	default:
+		g.plain = true
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return g.Read(p)
+}
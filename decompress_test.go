@@ -0,0 +1,103 @@
+package parallel
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+)
+
+// bzip2Data is "a\nb\nc\n", compressed with bzip2.
+var bzip2Data = []byte("BZh91AY&SY\x03\x89\x0c\xa6\x00\x00\x01\xc1\x00\x00\x108\x00 \x00!\x9ah3M\x1c\xb7\x8b\xb9\"\x9c(H\x01\xc4\x86S\x00")
+
+func gzipData(t *testing.T, s string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(zw, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	// A test format, prefixing the uncompressed data with its magic.
+	const magicTest = "\x00TEST"
+	RegisterDecompressor(magicTest, func(r io.Reader) (io.Reader, error) {
+		if _, err := io.CopyN(io.Discard, r, int64(len(magicTest))); err != nil {
+			return nil, err
+		}
+		return r, nil
+	})
+	t.Cleanup(func() {
+		decompressorsMu.Lock()
+		delete(decompressors, magicTest)
+		decompressorsMu.Unlock()
+	})
+	var cases = []struct {
+		about  string
+		input  []byte
+		result string
+		err    error
+	}{
+		{about: `Empty input.`, input: nil, result: ""},
+		{about: `Uncompressed input.`, input: []byte("a\nb\nc\n"), result: "A\nB\nC\n"},
+		{about: `Short uncompressed input.`, input: []byte("a"), result: "A\n"},
+		{about: `Gzip.`, input: gzipData(t, "a\nb\nc\n"), result: "A\nB\nC\n"},
+		{
+			about:  `Gzip members.`,
+			input:  append(gzipData(t, "a\nb\n"), gzipData(t, "c\n")...),
+			result: "A\nB\nC\n",
+		},
+		{
+			about:  `Gzip followed by plain data.`,
+			input:  append(gzipData(t, "a\nb\n"), "c\nd"...),
+			result: "A\nB\nC\nD\n",
+		},
+		{
+			about:  `Gzip members followed by plain data.`,
+			input:  append(append(gzipData(t, "a\n"), gzipData(t, "b\n")...), "c\n"...),
+			result: "A\nB\nC\n",
+		},
+		{
+			about:  `Record spanning gzip and plain data.`,
+			input:  append(gzipData(t, "a\nb"), "c\n"...),
+			result: "A\nBC\n",
+		},
+		{about: `Bzip2.`, input: bzip2Data, result: "A\nB\nC\n"},
+		{about: `Registered decompressor.`, input: []byte(magicTest + "a\nb\nc\n"), result: "A\nB\nC\n"},
+		{about: `Xz is not registered.`, input: []byte(MagicXZ + "data"), err: ErrUnsupportedCompression},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		p := NewProcessor(bytes.NewReader(c.input), &buf, ToTransformerFunc(bytes.ToUpper))
+		p.NumWorkers = 1
+		p.Decompress = true
+		if err := p.Run(); err != c.err {
+			t.Fatalf("[%s] p.Run: got %v, want %v", c.about, err, c.err)
+		}
+		if buf.String() != c.result {
+			t.Errorf("[%s] got %q, want %q", c.about, buf.String(), c.result)
+		}
+	}
+}
+
+func TestNewDecompressReader(t *testing.T) {
+	r, err := NewDecompressReader(bytes.NewReader(bzip2Data))
+	if err != nil {
+		t.Fatalf("NewDecompressReader: got %v, want nil", err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("io.ReadAll: got %v, want nil", err)
+	}
+	if string(b) != "a\nb\nc\n" {
+		t.Errorf("got %q, want %q", b, "a\nb\nc\n")
+	}
+	if _, err := NewDecompressReader(strings.NewReader(MagicGzip + "garbage")); err == nil {
+		t.Errorf("NewDecompressReader: got nil, want an error for a broken gzip header")
+	}
+}
@@ -0,0 +1,62 @@
+package parallel
+
+import (
+	"bytes"
+	"hash/fnv"
+)
+
+// DigestSink is a writer computing an order independent digest of the
+// records written to it, so outputs of runs with different worker
+// interleavings can be compared. Each record is hashed, including its
+// separator, and the hashes are summed. Use it as W directly, or wrap W with
+// io.MultiWriter to keep the output.
+type DigestSink struct {
+	Separator byte
+	sum       uint64
+	partial   []byte
+}
+
+// NewDigestSink returns a digest sink for newline separated records.
+func NewDigestSink() *DigestSink {
+	return &DigestSink{Separator: '\n'}
+}
+
+// Write hashes all complete records in p and keeps any trailing partial
+// record for the next call.
+func (s *DigestSink) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, s.Separator)
+		if i == -1 {
+			s.partial = append(s.partial, p...)
+			break
+		}
+		if len(s.partial) > 0 {
+			s.partial = append(s.partial, p[:i+1]...)
+			s.add(s.partial)
+			s.partial = s.partial[:0]
+		} else {
+			s.add(p[:i+1])
+		}
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// add adds the hash of a single record to the digest.
+func (s *DigestSink) add(b []byte) {
+	h := fnv.New64a()
+	_, _ = h.Write(b)
+	s.sum += h.Sum64()
+}
+
+// Digest returns the digest of all records written so far, including a last
+// record without a separator.
+func (s *DigestSink) Digest() uint64 {
+	if len(s.partial) > 0 {
+		h := fnv.New64a()
+		_, _ = h.Write(s.partial)
+		return s.sum + h.Sum64()
+	}
+	return s.sum
+}
@@ -0,0 +1,51 @@
+package parallel
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDigestSink(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&input, "record %d\n", i)
+	}
+	var digests []uint64
+	for _, numWorkers := range []int{1, 8} {
+		s := NewDigestSink()
+		p := NewProcessor(strings.NewReader(input.String()), s, ToTransformerFunc(bytes.ToUpper))
+		p.NumWorkers = numWorkers
+		p.BatchSize = 100
+		if err := p.Run(); err != nil {
+			t.Fatalf("p.Run: got %v, want nil", err)
+		}
+		digests = append(digests, s.Digest())
+	}
+	if digests[0] != digests[1] {
+		t.Errorf("got different digests %x and %x", digests[0], digests[1])
+	}
+	var cases = []struct {
+		about string
+		a, b  []string
+		equal bool
+	}{
+		{about: `Order does not matter.`, a: []string{"a\n", "b\n"}, b: []string{"b\n", "a\n"}, equal: true},
+		{about: `Write boundaries do not matter.`, a: []string{"a\nb\n"}, b: []string{"a", "\nb", "\n"}, equal: true},
+		{about: `Content matters.`, a: []string{"a\n", "b\n"}, b: []string{"a\n", "c\n"}, equal: false},
+		{about: `Duplicates matter.`, a: []string{"a\n", "a\n"}, b: []string{"b\n", "b\n"}, equal: false},
+	}
+	for _, c := range cases {
+		sa, sb := NewDigestSink(), NewDigestSink()
+		for _, v := range c.a {
+			fmt.Fprint(sa, v)
+		}
+		for _, v := range c.b {
+			fmt.Fprint(sb, v)
+		}
+		if got := sa.Digest() == sb.Digest(); got != c.equal {
+			t.Errorf("[%s] got %v, want %v", c.about, got, c.equal)
+		}
+	}
+}
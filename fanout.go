@@ -0,0 +1,128 @@
+package parallel
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"sync"
+)
+
+// firstError keeps the first error reported by any goroutine.
+type firstError struct {
+	mu  sync.Mutex
+	err error
+}
+
+// set records err, if no error has been recorded yet.
+func (e *firstError) set(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+// get returns the first recorded error, if any.
+func (e *firstError) get() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.err
+}
+
+// errorList collects errors from multiple goroutines.
+type errorList struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+// add records err and returns the number of errors recorded so far.
+func (e *errorList) add(err error) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.errs = append(e.errs, err)
+	return len(e.errs)
+}
+
+// join returns all recorded errors as a single error.
+func (e *errorList) join() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return errors.Join(e.errs...)
+}
+
+// fanout runs f over all items passed to emit by produce, using numWorkers
+// goroutines, and writes the results to w. Output order is not preserved. Once
+// a worker or write error occurred, emit returns false and produce should
+// stop. The error of produce takes precedence over worker or write errors.
+func fanout[T any](numWorkers int, w io.Writer, produce func(emit func(T) bool) error, f func(T) ([]byte, error)) error {
+	if numWorkers < 1 || numWorkers > MaxWorkers {
+		return ErrInvalidWorkers
+	}
+	var (
+		queue = make(chan T)
+		out   = make(chan []byte)
+		done  = make(chan bool)
+		wg    sync.WaitGroup
+		fErr  firstError
+	)
+	go func() {
+		bw := bufio.NewWriter(w)
+		for b := range out {
+			if _, err := bw.Write(b); err != nil {
+				fErr.set(err)
+			}
+		}
+		if err := bw.Flush(); err != nil {
+			fErr.set(err)
+		}
+		done <- true
+	}()
+	wg.Add(numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for item := range queue {
+				b, err := f(item)
+				if err != nil {
+					fErr.set(err)
+					continue
+				}
+				out <- b
+			}
+		}()
+	}
+	err := produce(func(item T) bool {
+		if fErr.get() != nil {
+			return false
+		}
+		queue <- item
+		return true
+	})
+	close(queue)
+	wg.Wait()
+	close(out)
+	<-done
+	if err != nil {
+		return err
+	}
+	return fErr.get()
+}
+
+// readRecords reads separator terminated records from r and passes them to
+// f, until f returns false or the input is exhausted. The last record may
+// lack a separator.
+func readRecords(r io.Reader, sep byte, f func([]byte) bool) error {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.ReadBytes(sep)
+		if len(b) > 0 && !f(b) {
+			return nil
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
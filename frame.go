@@ -0,0 +1,72 @@
+package parallel
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var (
+	// ErrInvalidLengthPrefix is returned, if LengthPrefixSize is not 1, 2, 4
+	// or 8.
+	ErrInvalidLengthPrefix = errors.New("length prefix size must be 1, 2, 4 or 8")
+	// ErrFrameTooLarge is returned, if a result does not fit the length
+	// prefix.
+	ErrFrameTooLarge = errors.New("result too large for length prefix")
+)
+
+// frameSink prefixes each non-empty result with its length. The writer
+// passes each result in a single Write call, so prefix and payload are always
+// written together.
+type frameSink struct {
+	sink
+	size  int
+	order binary.ByteOrder
+	buf   [8]byte
+}
+
+// newFrameSink wraps s, validating the prefix size.
+func newFrameSink(s sink, size int, order binary.ByteOrder) (*frameSink, error) {
+	switch size {
+	case 1, 2, 4, 8:
+	default:
+		return nil, ErrInvalidLengthPrefix
+	}
+	if order == nil {
+		order = binary.BigEndian
+	}
+	return &frameSink{sink: s, size: size, order: order}, nil
+}
+
+// Write writes the length prefix and p. Empty results are not written.
+func (s *frameSink) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n := uint64(len(p))
+	switch s.size {
+	case 1:
+		if n > math.MaxUint8 {
+			return 0, ErrFrameTooLarge
+		}
+		s.buf[0] = byte(n)
+	case 2:
+		if n > math.MaxUint16 {
+			return 0, ErrFrameTooLarge
+		}
+		s.order.PutUint16(s.buf[:], uint16(n))
+	case 4:
+		if n > math.MaxUint32 {
+			return 0, ErrFrameTooLarge
+		}
+		s.order.PutUint32(s.buf[:], uint32(n))
+	case 8:
+		s.order.PutUint64(s.buf[:], n)
+	}
+	k, err := s.sink.Write(s.buf[:s.size])
+	if err != nil {
+		return k, err
+	}
+	m, err := s.sink.Write(p)
+	return k + m, err
+}
@@ -0,0 +1,67 @@
+package parallel
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/miku/parallel/record"
+)
+
+// frames encodes payloads as length prefixed frames.
+func frames(order binary.ByteOrder, payloads ...string) []byte {
+	var buf bytes.Buffer
+	for _, p := range payloads {
+		_ = binary.Write(&buf, order, uint32(len(p)))
+		buf.WriteString(p)
+	}
+	return buf.Bytes()
+}
+
+func TestLengthPrefixedOutput(t *testing.T) {
+	input := frames(binary.BigEndian, "abc", "hello world", "x\ny")
+	var buf bytes.Buffer
+	p := NewProcessor(bytes.NewReader(input), &buf, ToTransformerFunc(bytes.ToUpper))
+	p.SplitFunc = record.NewLengthPrefixedSplitter(binary.BigEndian, 4)
+	p.LengthPrefixSize = 4
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	scanner := bufio.NewScanner(&buf)
+	scanner.Split(record.NewLengthPrefixedSplitter(binary.BigEndian, 4))
+	var result []string
+	for scanner.Scan() {
+		result = append(result, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("scanner: got %v, want nil", err)
+	}
+	sort.Strings(result)
+	expected := []string{"ABC", "HELLO WORLD", "X\nY"}
+	if strings.Join(result, ",") != strings.Join(expected, ",") {
+		t.Errorf("got %q, want %q", result, expected)
+	}
+}
+
+func TestLengthPrefixedOutputErrors(t *testing.T) {
+	var cases = []struct {
+		about string
+		size  int
+		input string
+		err   error
+	}{
+		{about: `Invalid prefix size.`, size: 3, input: "a\n", err: ErrInvalidLengthPrefix},
+		{about: `Result too large.`, size: 1, input: strings.Repeat("a", 300) + "\n", err: ErrFrameTooLarge},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		p := NewProcessor(strings.NewReader(c.input), &buf, ToTransformerFunc(bytes.ToUpper))
+		p.LengthPrefixSize = c.size
+		if err := p.Run(); err != c.err {
+			t.Errorf("[%s] got %v, want %v", c.about, err, c.err)
+		}
+	}
+}
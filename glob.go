@@ -0,0 +1,95 @@
+package parallel
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// FileTransformerFunc is a TransformerFunc that additionally receives the
+// name of the file a record was read from.
+type FileTransformerFunc func(filename string, b []byte) ([]byte, error)
+
+// fileRecord is a record together with the file it was read from.
+type fileRecord struct {
+	filename string
+	b        []byte
+}
+
+// GlobProcessor processes the records of all files matching a pattern, as
+// understood by filepath.Glob. Files are opened one at a time, when the
+// previous file has been read, so large globs do not exhaust file
+// descriptors. Matches, which are not regular files, are skipped. Output
+// order is not preserved.
+type GlobProcessor struct {
+	Pattern         string
+	RecordSeparator byte
+	NumWorkers      int
+	W               io.Writer
+	F               TransformerFunc
+	// FileF is used instead of F, if set, receiving the name of the file of
+	// each record, e.g. to tag results with their source.
+	FileF FileTransformerFunc
+}
+
+// NewGlobProcessor creates a new processor for all files matching pattern.
+func NewGlobProcessor(pattern string, w io.Writer, f TransformerFunc) *GlobProcessor {
+	return &GlobProcessor{
+		Pattern:         pattern,
+		RecordSeparator: '\n',
+		NumWorkers:      runtime.NumCPU(),
+		W:               w,
+		F:               f,
+	}
+}
+
+// Run expands the pattern and dispatches the records of all files to the
+// workers. It is not an error, if no file matches.
+func (p *GlobProcessor) Run() error {
+	return fanout(p.NumWorkers, p.W, p.records, func(r fileRecord) ([]byte, error) {
+		if p.FileF != nil {
+			return p.FileF(r.filename, r.b)
+		}
+		return p.F(r.b)
+	})
+}
+
+// records reads the matching files, one after another.
+func (p *GlobProcessor) records(emit func(fileRecord) bool) error {
+	filenames, err := filepath.Glob(p.Pattern)
+	if err != nil {
+		return err
+	}
+	for _, filename := range filenames {
+		ok, err := p.readFile(filename, emit)
+		if err != nil || !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFile emits all records of a single file and reports, whether to
+// continue with the next file. Matches, which are not regular files, like
+// directories, are skipped.
+func (p *GlobProcessor) readFile(filename string, emit func(fileRecord) bool) (bool, error) {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return false, err
+	}
+	if !fi.Mode().IsRegular() {
+		return true, nil
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	ok := true
+	err = readRecords(f, p.RecordSeparator, func(b []byte) bool {
+		ok = emit(fileRecord{filename: filename, b: b})
+		return ok
+	})
+	return ok, err
+}
@@ -0,0 +1,62 @@
+package parallel
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestGlobProcessor(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.txt": "1\n2\n",
+		"b.txt": "3\n4",
+		"c.log": "5\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	p := NewGlobProcessor(filepath.Join(dir, "*.txt"), &buf, nil)
+	p.FileF = func(filename string, b []byte) ([]byte, error) {
+		return []byte(filepath.Base(filename) + " " + string(bytes.TrimSpace(b)) + "\n"), nil
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	sort.Strings(lines)
+	expected := []string{"a.txt 1", "a.txt 2", "b.txt 3", "b.txt 4"}
+	if strings.Join(lines, ",") != strings.Join(expected, ",") {
+		t.Errorf("p.Run: got %v, want %v", lines, expected)
+	}
+	// Directories matching the pattern are skipped.
+	if err := os.Mkdir(filepath.Join(dir, "d.txt"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	sort.Strings(lines)
+	if strings.Join(lines, ",") != strings.Join(expected, ",") {
+		t.Errorf("p.Run: got %v, want %v", lines, expected)
+	}
+	// No matches.
+	buf.Reset()
+	p = NewGlobProcessor(filepath.Join(dir, "*.csv"), &buf, ToTransformerFunc(bytes.ToUpper))
+	if err := p.Run(); err != nil || buf.Len() > 0 {
+		t.Errorf("p.Run: got %v, %q, want nil, empty output", err, buf.String())
+	}
+	// Bad pattern.
+	p = NewGlobProcessor("[", &buf, ToTransformerFunc(bytes.ToUpper))
+	if err := p.Run(); err != filepath.ErrBadPattern {
+		t.Errorf("p.Run: got %v, want %v", err, filepath.ErrBadPattern)
+	}
+}
@@ -0,0 +1,107 @@
+package parallel
+
+import (
+	"io"
+	"runtime"
+)
+
+// GroupFunc transforms all records sharing a key.
+type GroupFunc func(key string, group [][]byte) ([]byte, error)
+
+// group is a key with its records.
+type group struct {
+	key     string
+	records [][]byte
+}
+
+// GroupProcessor groups records by a key and passes each complete group to a
+// worker. By default, the whole input is kept in memory, since a group is
+// only complete once all input has been read. If the input is sorted (or at
+// least clustered) by key, set Sorted: a group is then dispatched as soon as
+// the key changes and only a single group is kept in memory. With Sorted, a
+// key appearing again later starts a new group.
+type GroupProcessor struct {
+	KeyFunc         func([]byte) string
+	Sorted          bool
+	RecordSeparator byte
+	NumWorkers      int
+	R               io.Reader
+	W               io.Writer
+	F               GroupFunc
+}
+
+// NewGroupProcessor creates a new processor grouping records by the key
+// returned from keyFunc.
+func NewGroupProcessor(r io.Reader, w io.Writer, keyFunc func([]byte) string, f GroupFunc) *GroupProcessor {
+	return &GroupProcessor{
+		KeyFunc:         keyFunc,
+		RecordSeparator: '\n',
+		NumWorkers:      runtime.NumCPU(),
+		R:               r,
+		W:               w,
+		F:               f,
+	}
+}
+
+// Run groups the input and dispatches groups to the workers.
+func (p *GroupProcessor) Run() error {
+	produce := p.groups
+	if p.Sorted {
+		produce = p.sortedGroups
+	}
+	return fanout(p.NumWorkers, p.W, produce, func(g group) ([]byte, error) {
+		return p.F(g.key, g.records)
+	})
+}
+
+// groups reads the whole input and emits groups in order of first appearance
+// of their key.
+func (p *GroupProcessor) groups(emit func(group) bool) error {
+	var (
+		keys   []string
+		groups = make(map[string][][]byte)
+	)
+	err := readRecords(p.R, p.RecordSeparator, func(b []byte) bool {
+		key := p.KeyFunc(b)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], b)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if !emit(group{key: key, records: groups[key]}) {
+			return nil
+		}
+		delete(groups, key)
+	}
+	return nil
+}
+
+// sortedGroups emits a group, whenever the key changes.
+func (p *GroupProcessor) sortedGroups(emit func(group) bool) error {
+	var current *group
+	err := readRecords(p.R, p.RecordSeparator, func(b []byte) bool {
+		key := p.KeyFunc(b)
+		if current != nil && current.key != key {
+			g := *current
+			current = nil
+			if !emit(g) {
+				return false
+			}
+		}
+		if current == nil {
+			current = &group{key: key}
+		}
+		current.records = append(current.records, b)
+		return true
+	})
+	if err != nil || current == nil {
+		return err
+	}
+	emit(*current)
+	return nil
+}
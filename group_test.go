@@ -0,0 +1,61 @@
+package parallel
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestGroupProcessor(t *testing.T) {
+	// keyFunc uses the first tab separated field as key.
+	keyFunc := func(b []byte) string {
+		return string(bytes.SplitN(b, []byte("\t"), 2)[0])
+	}
+	// count emits the key and the number of records in the group.
+	count := func(key string, group [][]byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s %d\n", key, len(group))), nil
+	}
+	var cases = []struct {
+		about    string
+		input    string
+		sorted   bool
+		expected string
+	}{
+		{
+			about:    `Unsorted input, whole input grouped.`,
+			input:    "a\t1\nb\t2\na\t3\nc\t4\nb\t5\na\t6",
+			sorted:   false,
+			expected: "a 3\nb 2\nc 1\n",
+		},
+		{
+			about:    `Sorted input.`,
+			input:    "a\t1\na\t3\na\t6\nb\t2\nb\t5\nc\t4\n",
+			sorted:   true,
+			expected: "a 3\nb 2\nc 1\n",
+		},
+		{
+			about:    `Unsorted input with sorted fast path splits groups.`,
+			input:    "a\t1\nb\t2\na\t3\n",
+			sorted:   true,
+			expected: "a 1\nb 1\na 1\n",
+		},
+		{
+			about:    `Empty input.`,
+			input:    "",
+			sorted:   true,
+			expected: "",
+		},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		p := NewGroupProcessor(strings.NewReader(c.input), &buf, keyFunc, count)
+		p.Sorted = c.sorted
+		if err := p.Run(); err != nil {
+			t.Fatalf("[%s] got %v, want nil", c.about, err)
+		}
+		if !LinesEqual(buf.String(), c.expected) {
+			t.Errorf("[%s] got %q, want %q", c.about, buf.String(), c.expected)
+		}
+	}
+}
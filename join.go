@@ -0,0 +1,224 @@
+package parallel
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"runtime"
+)
+
+var (
+	// ErrJoinInput is returned, if a JoinProcessor has neither a Lookup nor
+	// a Secondary input.
+	ErrJoinInput = errors.New("join requires a lookup or a secondary input")
+	// ErrUnsortedInput is returned, if an input to a sorted merge join is not
+	// sorted by key.
+	ErrUnsortedInput = errors.New("join input not sorted by key")
+)
+
+// JoinFunc transforms a record together with the records of the secondary
+// input sharing its key. Matches is nil, if there are no such records.
+type JoinFunc func(record []byte, matches [][]byte) ([]byte, error)
+
+// joinItem is a primary record with its matches.
+type joinItem struct {
+	record  []byte
+	matches [][]byte
+}
+
+// JoinProcessor joins each record of R against records of a secondary input
+// with the same key and passes the record together with its matches to F in
+// a worker, so the order of the output is not preserved. Records without a
+// match are passed as well, F may drop them by returning an empty result.
+// Matches are shared by all records with the same key and must not be
+// modified.
+//
+// Secondary records are either preloaded into Lookup, see LoadLookup, or read
+// from Secondary in a sorted merge join. For the merge, both R and Secondary
+// must be sorted by key in ascending byte order, e.g. with "LC_ALL=C sort";
+// only the current group of secondary records is kept in memory. An input
+// found not to be sorted fails the run with ErrUnsortedInput.
+type JoinProcessor struct {
+	// KeyFunc returns the key of a record of R.
+	KeyFunc func([]byte) string
+	// SecondaryKeyFunc returns the key of a record of Secondary, defaults to
+	// KeyFunc.
+	SecondaryKeyFunc func([]byte) string
+	// Lookup maps keys to secondary records. Secondary is not read, if
+	// Lookup is set.
+	Lookup          map[string][][]byte
+	Secondary       io.Reader
+	RecordSeparator byte
+	NumWorkers      int
+	R               io.Reader
+	W               io.Writer
+	F               JoinFunc
+}
+
+// NewJoinProcessor creates a new processor, joining the records of r with
+// the records of the sorted secondary input by the key returned from keyFunc.
+func NewJoinProcessor(r, secondary io.Reader, w io.Writer, keyFunc func([]byte) string, f JoinFunc) *JoinProcessor {
+	return &JoinProcessor{
+		KeyFunc:         keyFunc,
+		Secondary:       secondary,
+		RecordSeparator: '\n',
+		NumWorkers:      runtime.NumCPU(),
+		R:               r,
+		W:               w,
+		F:               f,
+	}
+}
+
+// LoadLookup reads all records of r, in any order, into a lookup by the key
+// returned from keyFunc, e.g. for JoinProcessor.Lookup. Records keep their
+// separator.
+func LoadLookup(r io.Reader, sep byte, keyFunc func([]byte) string) (map[string][][]byte, error) {
+	lookup := make(map[string][][]byte)
+	err := readRecords(r, sep, func(b []byte) bool {
+		key := keyFunc(b)
+		lookup[key] = append(lookup[key], b)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lookup, nil
+}
+
+// Run reads all records and dispatches them with their matches to the
+// workers. Records keep their separator.
+func (p *JoinProcessor) Run() error {
+	produce := p.lookupJoin
+	switch {
+	case p.Lookup != nil:
+	case p.Secondary != nil:
+		produce = p.mergeJoin
+	default:
+		return ErrJoinInput
+	}
+	return fanout(p.NumWorkers, p.W, produce, func(v joinItem) ([]byte, error) {
+		return p.F(v.record, v.matches)
+	})
+}
+
+// lookupJoin emits each record with its matches from Lookup.
+func (p *JoinProcessor) lookupJoin(emit func(joinItem) bool) error {
+	return readRecords(p.R, p.RecordSeparator, func(b []byte) bool {
+		return emit(joinItem{record: b, matches: p.Lookup[p.KeyFunc(b)]})
+	})
+}
+
+// mergeJoin emits each record with its matches from the sorted secondary
+// input.
+func (p *JoinProcessor) mergeJoin(emit func(joinItem) bool) error {
+	keyFunc := p.SecondaryKeyFunc
+	if keyFunc == nil {
+		keyFunc = p.KeyFunc
+	}
+	var (
+		c = &joinCursor{
+			br:      bufio.NewReader(p.Secondary),
+			sep:     p.RecordSeparator,
+			keyFunc: keyFunc,
+		}
+		last    string
+		started bool
+		err     error
+	)
+	rerr := readRecords(p.R, p.RecordSeparator, func(b []byte) bool {
+		key := p.KeyFunc(b)
+		if started && key < last {
+			err = ErrUnsortedInput
+			return false
+		}
+		last, started = key, true
+		var matches [][]byte
+		if matches, err = c.matches(key); err != nil {
+			return false
+		}
+		return emit(joinItem{record: b, matches: matches})
+	})
+	if rerr != nil {
+		return rerr
+	}
+	return err
+}
+
+// joinCursor reads groups of records with the same key from a sorted input.
+type joinCursor struct {
+	br      *bufio.Reader
+	sep     byte
+	keyFunc func([]byte) string
+	// key and group are the current group, valid is false before the first
+	// and after the last group.
+	key   string
+	group [][]byte
+	valid bool
+	// next is the first record of the next group, with its key.
+	next    []byte
+	nextKey string
+	eof     bool
+}
+
+// matches returns the group with the given key, skipping groups with
+// smaller keys. Keys must be requested in ascending order.
+func (c *joinCursor) matches(key string) ([][]byte, error) {
+	if !c.valid && !c.eof {
+		if err := c.advance(); err != nil {
+			return nil, err
+		}
+	}
+	for c.valid && c.key < key {
+		if err := c.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if c.valid && c.key == key {
+		return c.group, nil
+	}
+	return nil, nil
+}
+
+// advance reads the next group.
+func (c *joinCursor) advance() error {
+	c.valid, c.group = false, nil
+	if c.next == nil {
+		if err := c.read(); err != nil || c.next == nil {
+			return err
+		}
+	}
+	c.key, c.group, c.valid = c.nextKey, [][]byte{c.next}, true
+	for {
+		if err := c.read(); err != nil {
+			return err
+		}
+		switch {
+		case c.next == nil:
+			return nil
+		case c.nextKey < c.key:
+			return ErrUnsortedInput
+		case c.nextKey > c.key:
+			return nil
+		}
+		c.group = append(c.group, c.next)
+	}
+}
+
+// read reads the next record into next, which is nil at the end of the
+// input.
+func (c *joinCursor) read() error {
+	c.next = nil
+	if c.eof {
+		return nil
+	}
+	b, err := c.br.ReadBytes(c.sep)
+	if err == io.EOF {
+		c.eof = true
+	} else if err != nil {
+		return err
+	}
+	if len(b) > 0 {
+		c.next, c.nextKey = b, c.keyFunc(b)
+	}
+	return nil
+}
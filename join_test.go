@@ -0,0 +1,98 @@
+package parallel
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestJoinProcessor(t *testing.T) {
+	// id returns the first tab separated field.
+	id := func(b []byte) string {
+		s, _, _ := strings.Cut(strings.TrimSpace(string(b)), "\t")
+		return s
+	}
+	// join appends the second field of all matches to the record.
+	join := func(record []byte, matches [][]byte) ([]byte, error) {
+		fields := []string{strings.TrimSpace(string(record))}
+		for _, m := range matches {
+			_, v, _ := strings.Cut(strings.TrimSpace(string(m)), "\t")
+			fields = append(fields, v)
+		}
+		return []byte(strings.Join(fields, "|") + "\n"), nil
+	}
+	var cases = []struct {
+		about     string
+		input     string
+		secondary string
+		lookup    bool
+		expected  []string
+		err       error
+	}{
+		{
+			about:     `Lookup by id.`,
+			input:     "2\tb\n1\ta\n3\tc\n2\tbb\n",
+			secondary: "1\tone\n2\ttwo\n2\tzwei\n",
+			lookup:    true,
+			expected:  []string{"1\ta|one", "2\tbb|two|zwei", "2\tb|two|zwei", "3\tc"},
+		},
+		{
+			about:     `Sorted merge.`,
+			input:     "1\ta\n2\tb\n2\tbb\n3\tc\n5\te",
+			secondary: "0\tzero\n2\ttwo\n2\tzwei\n3\tthree\n4\tfour\n",
+			expected:  []string{"1\ta", "2\tbb|two|zwei", "2\tb|two|zwei", "3\tc|three", "5\te"},
+		},
+		{
+			about:     `Sorted merge, empty secondary input.`,
+			input:     "1\ta\n",
+			secondary: "",
+			expected:  []string{"1\ta"},
+		},
+		{
+			about:     `Unsorted input.`,
+			input:     "2\tb\n1\ta\n",
+			secondary: "1\tone\n",
+			err:       ErrUnsortedInput,
+		},
+		{
+			about:     `Unsorted secondary input.`,
+			input:     "1\ta\n3\tc\n",
+			secondary: "2\ttwo\n1\tone\n",
+			err:       ErrUnsortedInput,
+		},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		p := NewJoinProcessor(strings.NewReader(c.input), strings.NewReader(c.secondary), &buf, id, join)
+		if c.lookup {
+			lookup, err := LoadLookup(strings.NewReader(c.secondary), '\n', id)
+			if err != nil {
+				t.Fatalf("[%s] LoadLookup: got %v, want nil", c.about, err)
+			}
+			p.Lookup = lookup
+			p.Secondary = nil
+		}
+		if err := p.Run(); err != c.err {
+			t.Fatalf("[%s] got %v, want %v", c.about, err, c.err)
+		}
+		if c.err != nil {
+			continue
+		}
+		var result []string
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if line != "" {
+				result = append(result, line)
+			}
+		}
+		sort.Strings(result)
+		if !reflect.DeepEqual(result, c.expected) {
+			t.Errorf("[%s] got %q, want %q", c.about, result, c.expected)
+		}
+	}
+	p := NewJoinProcessor(strings.NewReader("1\n"), nil, &bytes.Buffer{}, id, join)
+	if err := p.Run(); err != ErrJoinInput {
+		t.Errorf("got %v, want %v", err, ErrJoinInput)
+	}
+}
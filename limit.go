@@ -0,0 +1,15 @@
+package parallel
+
+// LimitConcurrency returns a transformer, that runs f with at most n
+// concurrent calls, regardless of the number of workers. Use it for the
+// expensive part of a transformation only, e.g. calls to an external service,
+// while other work still runs on all workers. A limit of less than one is
+// treated as one.
+func LimitConcurrency(f TransformerFunc, n int) TransformerFunc {
+	sem := make(chan struct{}, max(n, 1))
+	return func(b []byte) ([]byte, error) {
+		sem <- struct{}{}
+		defer func() { <-sem }()
+		return f(b)
+	}
+}
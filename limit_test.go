@@ -0,0 +1,43 @@
+package parallel
+
+import (
+	"bytes"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLimitConcurrency(t *testing.T) {
+	var (
+		running atomic.Int64
+		peak    atomic.Int64
+	)
+	expensive := LimitConcurrency(func(b []byte) ([]byte, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return bytes.ToUpper(b), nil
+	}, 4)
+	var buf bytes.Buffer
+	p := NewProcessor(strings.NewReader(strings.Repeat("a\n", 200)), &buf, func(b []byte) ([]byte, error) {
+		return expensive(bytes.TrimSpace(b))
+	})
+	p.NumWorkers = 32
+	p.BatchSize = 1
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if buf.String() != strings.Repeat("A", 200) {
+		t.Errorf("p.Run: got %q, want %q", buf.String(), strings.Repeat("A", 200))
+	}
+	if n := peak.Load(); n > 4 || n < 1 {
+		t.Errorf("got %d concurrent calls, want between 1 and 4", n)
+	}
+}
@@ -0,0 +1,93 @@
+package parallel
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+// ErrMemoizedPanic is returned to calls waiting for a memoized call of the
+// same input, if that call panicked.
+var ErrMemoizedPanic = errors.New("memoized call panicked")
+
+// memoEntry is a cached result.
+type memoEntry struct {
+	key   string
+	value []byte
+}
+
+// memoCall is a computation in flight.
+type memoCall struct {
+	wg    sync.WaitGroup
+	value []byte
+	err   error
+}
+
+// memo is a least recently used cache, that computes each key only once,
+// even if requested concurrently.
+type memo struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	entries  map[string]*list.Element
+	inflight map[string]*memoCall
+}
+
+// get returns the cached value for key or computes it with f.
+func (m *memo) get(key string, f func() ([]byte, error)) ([]byte, error) {
+	m.mu.Lock()
+	if e, ok := m.entries[key]; ok {
+		m.ll.MoveToFront(e)
+		m.mu.Unlock()
+		return e.Value.(*memoEntry).value, nil
+	}
+	if c, ok := m.inflight[key]; ok {
+		m.mu.Unlock()
+		c.wg.Wait()
+		return c.value, c.err
+	}
+	// The error is kept, if f panics. The call is completed in a defer, so
+	// waiters do not block forever in that case.
+	c := &memoCall{err: ErrMemoizedPanic}
+	c.wg.Add(1)
+	m.inflight[key] = c
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.inflight, key)
+		c.wg.Done()
+		if c.err == nil {
+			m.entries[key] = m.ll.PushFront(&memoEntry{key: key, value: c.value})
+			if m.ll.Len() > m.capacity {
+				e := m.ll.Back()
+				m.ll.Remove(e)
+				delete(m.entries, e.Value.(*memoEntry).key)
+			}
+		}
+	}()
+	c.value, c.err = f()
+	return c.value, c.err
+}
+
+// MemoizeFunc returns a transformer, that caches the results of f for up to
+// capacity distinct inputs, evicting the least recently used. Concurrent
+// calls with the same input wait for a single call of f. Errors are not
+// cached. If f panics, waiting calls fail with ErrMemoizedPanic. Results are
+// shared between calls, so they must not be modified.
+func MemoizeFunc(f TransformerFunc, capacity int) TransformerFunc {
+	if capacity < 1 {
+		capacity = 1
+	}
+	m := &memo{
+		capacity: capacity,
+		ll:       list.New(),
+		entries:  make(map[string]*list.Element),
+		inflight: make(map[string]*memoCall),
+	}
+	return func(b []byte) ([]byte, error) {
+		return m.get(string(b), func() ([]byte, error) {
+			return f(b)
+		})
+	}
+}
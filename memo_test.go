@@ -0,0 +1,123 @@
+package parallel
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoizeFunc(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls = make(map[string]int)
+	)
+	f := MemoizeFunc(func(b []byte) ([]byte, error) {
+		mu.Lock()
+		calls[string(b)]++
+		mu.Unlock()
+		return bytes.ToUpper(b), nil
+	}, 10)
+	input := strings.Repeat("a\nb\nc\n", 1000)
+	var buf bytes.Buffer
+	p := NewProcessor(strings.NewReader(input), &buf, f)
+	p.NumWorkers = 8
+	p.BatchSize = 10
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if !LinesEqual(buf.String(), strings.ToUpper(input)) {
+		t.Errorf("p.Run: output differs")
+	}
+	for _, k := range []string{"a\n", "b\n", "c\n"} {
+		if calls[k] != 1 {
+			t.Errorf("got %d calls for %q, want 1", calls[k], k)
+		}
+	}
+}
+
+func TestMemoizeFuncEviction(t *testing.T) {
+	var (
+		calls   int
+		errFail = errors.New("fail")
+	)
+	f := MemoizeFunc(func(b []byte) ([]byte, error) {
+		calls++
+		if string(b) == "x" {
+			return nil, errFail
+		}
+		return b, nil
+	}, 2)
+	var cases = []struct {
+		input string
+		calls int
+		err   error
+	}{
+		{"a", 1, nil},
+		{"b", 2, nil},
+		{"a", 2, nil}, // cached, a is now most recently used
+		{"c", 3, nil}, // evicts b
+		{"a", 3, nil},
+		{"b", 4, nil},
+		{"x", 5, errFail},
+		{"x", 6, errFail}, // errors are not cached
+	}
+	for _, c := range cases {
+		b, err := f([]byte(c.input))
+		if err != c.err {
+			t.Fatalf("[%s] got %v, want %v", c.input, err, c.err)
+		}
+		if err == nil && string(b) != c.input {
+			t.Errorf("[%s] got %q, want %q", c.input, b, c.input)
+		}
+		if calls != c.calls {
+			t.Errorf("[%s] got %d calls, want %d", c.input, calls, c.calls)
+		}
+	}
+}
+
+func TestMemoizeFuncPanic(t *testing.T) {
+	var (
+		calls   atomic.Int64
+		release = make(chan struct{})
+	)
+	f := MemoizeFunc(func(b []byte) ([]byte, error) {
+		if calls.Add(1) == 1 {
+			<-release
+			panic("boom")
+		}
+		return b, nil
+	}, 10)
+	go func() {
+		defer func() { _ = recover() }()
+		_, _ = f([]byte("a"))
+	}()
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	errC := make(chan error)
+	go func() {
+		_, err := f([]byte("a"))
+		errC <- err
+	}()
+	// Give the second call time to wait for the first one.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	select {
+	case err := <-errC:
+		// The second call may also have arrived after the panic, then it
+		// computes the value itself.
+		if err != nil && err != ErrMemoizedPanic {
+			t.Fatalf("got %v, want %v or nil", err, ErrMemoizedPanic)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting call blocked after a panic")
+	}
+	// The panic is not cached.
+	if b, err := f([]byte("a")); string(b) != "a" || err != nil {
+		t.Errorf("got %q, %v, want %q, nil", b, err, "a")
+	}
+}
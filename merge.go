@@ -0,0 +1,99 @@
+package parallel
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"io"
+)
+
+// mergeItem is the current line of one of the merged readers.
+type mergeItem struct {
+	line  []byte
+	index int
+	br    *bufio.Reader
+}
+
+// mergeHeap orders the current lines of all readers. Ties are broken by the
+// reader index, which keeps the merge stable.
+type mergeHeap struct {
+	items []*mergeItem
+	less  func(a, b []byte) bool
+}
+
+func (h *mergeHeap) Len() int { return len(h.items) }
+
+func (h *mergeHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	switch {
+	case h.less(a.line, b.line):
+		return true
+	case h.less(b.line, a.line):
+		return false
+	default:
+		return a.index < b.index
+	}
+}
+
+func (h *mergeHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *mergeHeap) Push(x any) { h.items = append(h.items, x.(*mergeItem)) }
+
+func (h *mergeHeap) Pop() any {
+	n := len(h.items)
+	item := h.items[n-1]
+	h.items = h.items[:n-1]
+	return item
+}
+
+// readLine returns the next line without the trailing newline. Returns
+// io.EOF, if there are no more lines.
+func readLine(br *bufio.Reader) ([]byte, error) {
+	b, err := br.ReadBytes('\n')
+	if len(b) > 0 && (err == nil || err == io.EOF) {
+		return bytes.TrimSuffix(b, []byte("\n")), nil
+	}
+	return nil, err
+}
+
+// MergeSortedReaders merges lines from readers, each of which must already be
+// sorted according to less, into a single sorted stream written to w. Lines
+// are passed to less without the trailing newline and every line written is
+// terminated by a newline. The merge is not parallel itself, but it is
+// useful to combine sorted shards of a parallel run.
+func MergeSortedReaders(readers []io.Reader, less func(a, b []byte) bool, w io.Writer) error {
+	h := &mergeHeap{less: less}
+	for i, r := range readers {
+		br := bufio.NewReader(r)
+		line, err := readLine(br)
+		if err == io.EOF {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		h.items = append(h.items, &mergeItem{line: line, index: i, br: br})
+	}
+	heap.Init(h)
+	bw := bufio.NewWriter(w)
+	for h.Len() > 0 {
+		item := h.items[0]
+		if _, err := bw.Write(item.line); err != nil {
+			return err
+		}
+		if err := bw.WriteByte('\n'); err != nil {
+			return err
+		}
+		line, err := readLine(item.br)
+		switch {
+		case err == io.EOF:
+			heap.Pop(h)
+		case err != nil:
+			return err
+		default:
+			item.line = line
+			heap.Fix(h, 0)
+		}
+	}
+	return bw.Flush()
+}
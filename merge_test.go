@@ -0,0 +1,51 @@
+package parallel
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMergeSortedReaders(t *testing.T) {
+	lessInt := func(a, b []byte) bool {
+		x, _ := strconv.Atoi(string(a))
+		y, _ := strconv.Atoi(string(b))
+		return x < y
+	}
+	var cases = []struct {
+		about    string
+		inputs   []string
+		expected string
+	}{
+		{
+			about:    `No readers.`,
+			inputs:   nil,
+			expected: "",
+		},
+		{
+			about:    `Three sorted readers.`,
+			inputs:   []string{"1\n4\n7\n10\n", "2\n5\n8\n", "3\n6\n9\n11\n12\n"},
+			expected: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+		},
+		{
+			about:    `Empty readers, duplicates and missing final newline.`,
+			inputs:   []string{"", "1\n3\n3", "2\n3\n"},
+			expected: "1\n2\n3\n3\n3\n",
+		},
+	}
+	for _, c := range cases {
+		var readers []io.Reader
+		for _, s := range c.inputs {
+			readers = append(readers, strings.NewReader(s))
+		}
+		var buf bytes.Buffer
+		if err := MergeSortedReaders(readers, lessInt, &buf); err != nil {
+			t.Fatalf("[%s] got %v, want nil", c.about, err)
+		}
+		if buf.String() != c.expected {
+			t.Errorf("[%s] got %q, want %q", c.about, buf.String(), c.expected)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package parallel
+
+import (
+	"io"
+	"runtime"
+)
+
+// PairwiseFunc transforms a record, given the record following it. The next
+// record is nil for the last record.
+type PairwiseFunc func(current, next []byte) ([]byte, error)
+
+// pair is a record and its successor.
+type pair struct {
+	current, next []byte
+}
+
+// PairwiseProcessor processes records together with the following record,
+// e.g. to compute differences between adjacent records. The reader keeps a
+// lookahead of one record, the pairs are passed to F in workers, so the order
+// of the output is not preserved.
+type PairwiseProcessor struct {
+	RecordSeparator byte
+	NumWorkers      int
+	R               io.Reader
+	W               io.Writer
+	F               PairwiseFunc
+}
+
+// NewPairwiseProcessor creates a new pairwise processor.
+func NewPairwiseProcessor(r io.Reader, w io.Writer, f PairwiseFunc) *PairwiseProcessor {
+	return &PairwiseProcessor{
+		RecordSeparator: '\n',
+		NumWorkers:      runtime.NumCPU(),
+		R:               r,
+		W:               w,
+		F:               f,
+	}
+}
+
+// Run reads all records and dispatches each record with its successor to the
+// workers. Records keep their separator.
+func (p *PairwiseProcessor) Run() error {
+	return fanout(p.NumWorkers, p.W, p.pairs, func(v pair) ([]byte, error) {
+		return p.F(v.current, v.next)
+	})
+}
+
+// pairs emits each record, once the next record has been read.
+func (p *PairwiseProcessor) pairs(emit func(pair) bool) error {
+	var prev []byte
+	err := readRecords(p.R, p.RecordSeparator, func(b []byte) bool {
+		if prev != nil && !emit(pair{current: prev, next: b}) {
+			return false
+		}
+		prev = b
+		return true
+	})
+	if err != nil || prev == nil {
+		return err
+	}
+	emit(pair{current: prev})
+	return nil
+}
@@ -0,0 +1,69 @@
+package parallel
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPairwiseProcessor(t *testing.T) {
+	// delta returns the difference to the next integer, or "end".
+	delta := func(current, next []byte) ([]byte, error) {
+		a, err := strconv.Atoi(string(bytes.TrimSpace(current)))
+		if err != nil {
+			return nil, err
+		}
+		if next == nil {
+			return []byte(strconv.Itoa(a) + ":end\n"), nil
+		}
+		b, err := strconv.Atoi(string(bytes.TrimSpace(next)))
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(a) + ":" + strconv.Itoa(b-a) + "\n"), nil
+	}
+	var cases = []struct {
+		about    string
+		input    string
+		expected []string
+		err      bool
+	}{
+		{about: `Empty input.`, input: "", expected: nil},
+		{about: `Single record.`, input: "5\n", expected: []string{"5:end"}},
+		{
+			about:    `Differences between consecutive lines.`,
+			input:    "1\n4\n9\n16\n10",
+			expected: []string{"10:end", "16:-6", "1:3", "4:5", "9:7"},
+		},
+		{about: `Transformer error.`, input: "1\nx\n", err: true},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		p := NewPairwiseProcessor(strings.NewReader(c.input), &buf, delta)
+		err := p.Run()
+		if c.err {
+			var numErr *strconv.NumError
+			if !errors.As(err, &numErr) {
+				t.Errorf("[%s] got %v, want a *strconv.NumError", c.about, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("[%s] got %v, want nil", c.about, err)
+		}
+		var result []string
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if line != "" {
+				result = append(result, line)
+			}
+		}
+		sort.Strings(result)
+		if !reflect.DeepEqual(result, c.expected) {
+			t.Errorf("[%s] got %v, want %v", c.about, result, c.expected)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package parallel
+
+import "sync"
+
+// Pool is a set of long-lived goroutines, which run the workers of one or
+// more processors, so that repeated runs, e.g. one per job in a service, do
+// not start and stop goroutines each time. Workers stay on the pool for the
+// duration of a run. If all pool goroutines are busy, e.g. with overlapping
+// runs, additional workers get a goroutine of their own.
+type Pool struct {
+	tasks chan poolTask
+	size  int
+	wg    sync.WaitGroup
+	// mu protects busy, the number of goroutines assigned a task.
+	mu   sync.Mutex
+	busy int
+	// spawned counts tasks, which did not fit the pool.
+	spawned int
+}
+
+// poolTask is a function to run, and an optional function to call, once the
+// goroutine running it is available again.
+type poolTask struct {
+	f, done func()
+}
+
+// NewPool starts a pool of n goroutines.
+func NewPool(n int) *Pool {
+	p := &Pool{tasks: make(chan poolTask), size: n}
+	p.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer p.wg.Done()
+			for t := range p.tasks {
+				t.f()
+				p.mu.Lock()
+				p.busy--
+				p.mu.Unlock()
+				if t.done != nil {
+					t.done()
+				}
+			}
+		}()
+	}
+	return p
+}
+
+// Go runs f on a pool goroutine, or on a new goroutine, if all pool
+// goroutines are busy.
+func (p *Pool) Go(f func()) {
+	p.run(f, nil)
+}
+
+// run is like Go, but calls done after f, once the goroutine is no longer
+// counted as busy, so a run waiting for done can start the next one on the
+// same goroutines.
+func (p *Pool) run(f, done func()) {
+	p.mu.Lock()
+	if p.busy < p.size {
+		// A goroutine is idle or just finished its task, so the send will
+		// not block for long.
+		p.busy++
+		p.mu.Unlock()
+		p.tasks <- poolTask{f: f, done: done}
+		return
+	}
+	p.spawned++
+	p.mu.Unlock()
+	go func() {
+		f()
+		if done != nil {
+			done()
+		}
+	}()
+}
+
+// Close stops the pool goroutines, after their current tasks finished. The
+// pool must not be used after Close.
+func (p *Pool) Close() {
+	close(p.tasks)
+	p.wg.Wait()
+}
@@ -0,0 +1,94 @@
+package parallel
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPool(t *testing.T) {
+	pool := NewPool(4)
+	defer pool.Close()
+	var cases = []struct {
+		about  string
+		input  string
+		result string
+	}{
+		{about: `First job.`, input: "a\nb\n", result: "A\nB\n"},
+		{about: `Second job.`, input: "c\n", result: "C\n"},
+		{about: `Empty job.`, input: "", result: ""},
+		{about: `Fourth job.`, input: "d\ne\nf\n", result: "D\nE\nF\n"},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		p := NewProcessor(strings.NewReader(c.input), &buf, ToTransformerFunc(bytes.ToUpper))
+		p.NumWorkers = 4
+		p.BatchSize = 1
+		p.Pool = pool
+		if err := p.Run(); err != nil {
+			t.Fatalf("[%s] p.Run: got %v, want nil", c.about, err)
+		}
+		if !LinesEqual(buf.String(), c.result) {
+			t.Errorf("[%s] got %q, want %q", c.about, buf.String(), c.result)
+		}
+	}
+	if pool.spawned != 0 {
+		t.Errorf("got %d spawned goroutines, want 0", pool.spawned)
+	}
+}
+
+func TestPoolOverlappingRuns(t *testing.T) {
+	pool := NewPool(2)
+	defer pool.Close()
+	var (
+		wg      sync.WaitGroup
+		results = make([]bytes.Buffer, 8)
+	)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			input := strings.Repeat(fmt.Sprintf("%d\n", i), 100)
+			p := NewProcessor(strings.NewReader(input), &results[i], func(b []byte) ([]byte, error) {
+				return b, nil
+			})
+			p.NumWorkers = 2
+			p.BatchSize = 10
+			p.Pool = pool
+			if err := p.Run(); err != nil {
+				t.Errorf("p.Run: got %v, want nil", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i := range results {
+		if want := strings.Repeat(fmt.Sprintf("%d\n", i), 100); results[i].String() != want {
+			t.Errorf("job %d: got %d bytes, want %d", i, results[i].Len(), len(want))
+		}
+	}
+}
+
+func TestPoolRerun(t *testing.T) {
+	// Workers are no longer busy, once Run returns, so rerunning right
+	// away does not spawn extra goroutines.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+	pool := NewPool(4)
+	defer pool.Close()
+	for i := 0; i < 1000; i++ {
+		p := NewProcessor(strings.NewReader("a\n"), io.Discard, ToTransformerFunc(bytes.ToUpper))
+		p.NumWorkers = 4
+		p.Pool = pool
+		if err := p.Run(); err != nil {
+			t.Fatalf("p.Run: got %v, want nil", err)
+		}
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.spawned != 0 {
+		t.Errorf("got %d spawned goroutines, want 0", pool.spawned)
+	}
+}
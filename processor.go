@@ -4,20 +4,98 @@ package parallel
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
 	"io"
 	"log"
 	"runtime"
+	"sort"
 	"sync"
 	"time"
 )
 
+// MaxWorkers is the maximum number of workers a processor accepts.
+const MaxWorkers = 65536
+
+// initialAdaptiveBatchSize is the size of the first batch with
+// AdaptiveBatchSize.
+const initialAdaptiveBatchSize = 16
+
+var (
+	// ErrRecordTimeout is returned, if a single transformer call exceeds the
+	// configured PerRecordTimeout.
+	ErrRecordTimeout = errors.New("record timeout")
+	// ErrInvalidWorkers is returned, if the number of workers is not between
+	// one and MaxWorkers.
+	ErrInvalidWorkers = errors.New("number of workers must be between 1 and MaxWorkers")
+	// ErrTransformer is returned, if not exactly one transformer is set, out
+	// of F, InPlaceF, OffsetF, ContextF, SharedF, AuxF and RouteF. With
+	// Identity, no transformer is required.
+	ErrTransformer = errors.New("exactly one transformer must be set")
+	// ErrTooManyErrors is returned together with all transformer errors, if
+	// their number exceeds MaxErrors.
+	ErrTooManyErrors = errors.New("too many errors")
+	// ErrOutputOffsetWorkers is returned, if OutputOffset is set with more
+	// than one worker.
+	ErrOutputOffsetWorkers = errors.New("output offset requires a single worker")
+)
+
+// Logger receives log output, e.g. an adapter for a structured logger. A
+// *log.Logger is a Logger.
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+// Workload is a hint about the kind of work a transformer does.
+type Workload int
+
+const (
+	// IOBound transformers spend most of their time waiting, e.g. on the
+	// network, so running more workers than cores helps. This is the default
+	// and NumWorkers is used as is.
+	IOBound Workload = iota
+	// CPUBound transformers keep a core busy. More workers than cores only
+	// add scheduling overhead, so the number of workers is limited to
+	// GOMAXPROCS and each worker is locked to an OS thread.
+	CPUBound
+)
+
 // SimpleTransformerFunc converts bytes to bytes.
 type SimpleTransformerFunc func([]byte) []byte
 
 // TransformerFunc takes a slice of bytes and returns a slice of bytes and a
-// an error. A common denominator of functions that transform data.
+// an error. A common denominator of functions that transform data. Every
+// record has its own buffer, which is never reused, so the result may alias
+// the input, e.g. a sub-slice for a zero-copy filter.
 type TransformerFunc func([]byte) ([]byte, error)
 
+// ContextTransformerFunc is a TransformerFunc that can be cancelled through
+// its context.
+type ContextTransformerFunc func(context.Context, []byte) ([]byte, error)
+
+// SharedTransformerFunc is a TransformerFunc that additionally receives a
+// value shared across all workers, e.g. a lookup table.
+type SharedTransformerFunc func(shared any, b []byte) ([]byte, error)
+
+// AuxTransformerFunc is a TransformerFunc that additionally returns auxiliary
+// output, e.g. diagnostics, which is kept separate from the results.
+type AuxTransformerFunc func([]byte) (out []byte, aux []byte, err error)
+
+// OffsetTransformerFunc is a TransformerFunc that additionally receives the
+// byte offset of the record in the input stream.
+type OffsetTransformerFunc func(offset int64, b []byte) ([]byte, error)
+
+// RouteTransformerFunc is a TransformerFunc that additionally returns the name
+// of the output, the result should be written to.
+type RouteTransformerFunc func([]byte) (out []byte, route string, err error)
+
+// InPlaceTransformerFunc modifies a record in place, e.g. for transformations
+// that do not change its length, like mapping bytes.
+type InPlaceTransformerFunc func([]byte)
+
 // ToTransformerFunc takes a simple transformer and wraps it so it can be used in
 // places where a TransformerFunc is expected.
 func ToTransformerFunc(f SimpleTransformerFunc) TransformerFunc {
@@ -26,16 +104,368 @@ func ToTransformerFunc(f SimpleTransformerFunc) TransformerFunc {
 	}
 }
 
+// FilterMap returns a transformer, that applies transform to records for
+// which keep returns true and drops all other records. Dropped records
+// yield an empty, non-nil slice, so they are dropped with
+// NilMeansPassthrough, too.
+func FilterMap(keep func([]byte) bool, transform TransformerFunc) TransformerFunc {
+	return func(b []byte) ([]byte, error) {
+		if !keep(b) {
+			return []byte{}, nil
+		}
+		return transform(b)
+	}
+}
+
+// Chain returns a transformer, that applies funcs in sequence, each to the
+// result of the previous one, within a single call. It stops at the first
+// error, or at an empty result, which drops the record, and returns that
+// result as it is, so with NilMeansPassthrough, a nil result of any stage
+// writes the original record, not the result of the previous stage.
+func Chain(funcs ...TransformerFunc) TransformerFunc {
+	return func(b []byte) ([]byte, error) {
+		for _, f := range funcs {
+			var err error
+			if b, err = f(b); err != nil || len(b) == 0 {
+				return b, err
+			}
+		}
+		return b, nil
+	}
+}
+
 // Processor can process lines in parallel.
 type Processor struct {
 	BatchSize       int
 	RecordSeparator byte
 	NumWorkers      int
 	SkipEmptyLines  bool
-	Verbose         bool
-	R               io.Reader
-	W               io.Writer
-	F               TransformerFunc
+	// SkipFunc, if set, replaces SkipEmptyLines and drops every record, for
+	// which it returns true, e.g. to also drop comment-only lines, or to
+	// keep blank lines inside a quoted multi-line value. It runs on the
+	// reading goroutine, like PreFilter.
+	SkipFunc func([]byte) bool
+	// StopOnSentinel, if set, stops reading, once a record equal to it,
+	// without its separator, is read, e.g. "__END__" on an interactive
+	// standard input, which does not need to be closed. The sentinel is not
+	// processed; records read before it are processed and written, and Run
+	// returns nil. Input following the sentinel may have been buffered
+	// already and is discarded. With a SplitFunc, tokens are compared as
+	// they are.
+	StopOnSentinel []byte
+	// StripSeparator removes RecordSeparator from every record passed to the
+	// transformer. By default, records end with RecordSeparator, which is
+	// added to an unterminated last record.
+	StripSeparator bool
+	// Verbose logs progress to Logger.
+	Verbose bool
+	// Logger defaults to the standard logger.
+	Logger Logger
+	// PassthroughOnError writes records, for which F returns an error, to W
+	// unchanged. The error is then not reported at all, so this takes
+	// precedence over the default policy of failing the run with a
+	// transformer error.
+	PassthroughOnError bool
+	// NilMeansPassthrough writes records, for which F returns a nil slice
+	// and no error, to W unchanged. An empty, non-nil slice, like []byte{},
+	// still drops the record. By default, both nil and empty results drop
+	// the record, so filters need to return an explicit empty slice with
+	// this option set, as FilterMap does.
+	NilMeansPassthrough bool
+	// Identity declares, that records are written unchanged. Records are
+	// then copied from R to W by the reading goroutine, without starting any
+	// workers, the transformer is not called. Splitting and record
+	// selection, like SkipEmptyLines or PreFilter, still apply, as well as
+	// MaxOutputBytes, Pause and Flush. Of the statistics, only Written and
+	// Elapsed are set.
+	Identity bool
+	// SortBatchFunc, if set, sorts the results of each batch before they are
+	// written, so the output consists of sorted runs of up to BatchSize
+	// results, while the batches themselves are still unordered.
+	SortBatchFunc func(a, b []byte) bool
+	// ReorderWindow, if set, writes results in input order, as long as no
+	// batch is more than ReorderWindow batches late. Up to ReorderWindow
+	// completed batches are buffered, waiting for an earlier batch. Once the
+	// buffer is full, the missing batch is given up on and written as soon
+	// as it arrives, so a single slow record does not stall the output. With
+	// a BatchSize of one, the window is measured in records.
+	ReorderWindow int
+	// AdaptiveBatchSize starts with a small batch and doubles the batch size
+	// with each batch up to BatchSize, so the first results are available
+	// early, even with a large BatchSize and a small or slow input.
+	AdaptiveBatchSize bool
+	// Affinity assigns batches to workers in turn, the k-th batch goes to
+	// worker k modulo the number of workers, instead of passing batches to
+	// the next idle worker. This keeps contiguous ranges of records on the
+	// same worker, at the cost of waiting for a busy worker.
+	Affinity bool
+	// KeyFunc, if set, keeps the results of records with the same key in
+	// input order, while records with different keys are still processed in
+	// parallel. Records are partitioned by a hash of their key, each worker
+	// receives the records of its keys in order. Keys sharing a worker may
+	// delay each other. SortBatchFunc and ReorderWindow reorder results and
+	// should not be combined with KeyFunc.
+	KeyFunc func([]byte) string
+	// MaxErrors is the number of transformer errors tolerated, records
+	// failing are skipped. Once the number of errors exceeds MaxErrors, no
+	// further records are processed and Run returns ErrTooManyErrors joined
+	// with the transformer errors. Zero means, the first error fails the
+	// run. Passthrough and dead letter records do not count as errors.
+	MaxErrors int
+	// DeadLetterWriter receives the raw input of every record, on which
+	// the transformer failed. The error then does not fail the run. With
+	// PassthroughOnError, failed records are written to both W and
+	// DeadLetterWriter.
+	DeadLetterWriter io.Writer
+	// DroppedWriter receives the raw input of every record, for which the
+	// transformer returned an empty result without an error, e.g. records
+	// removed by a filter, for auditing. Records skipped before the
+	// transformer, like empty lines or by PreFilter, are not included.
+	DroppedWriter io.Writer
+	// DeadLetterIncludeError appends a tab and the error message to each
+	// dead letter record, which is then terminated by a newline.
+	DeadLetterIncludeError bool
+	// AutoDetectLineEnding looks at the first record read and, if it
+	// ends with "\r\n", removes the carriage return from all records, which
+	// then end with "\n" only. Detection happens once, so with mixed line
+	// endings, records ending with "\n" are passed as they are, and no
+	// carriage returns are removed, if the first line ends with "\n". Only
+	// applies, if RecordSeparator is "\n" and no SplitFunc is set.
+	AutoDetectLineEnding bool
+	// PreserveComments writes blank lines and lines starting with
+	// CommentPrefix unchanged, bypassing SkipEmptyLines, PreFilter and the
+	// transformer. Since output order is not preserved, this is only useful
+	// with a single worker, see SingleWorkerOrdered.
+	PreserveComments bool
+	// CommentPrefix defaults to "#".
+	CommentPrefix []byte
+	// RouteF is used instead of F, if set. Each result is written to the
+	// writer for its route, looked up in Routes first, then created by
+	// RouteWriter. Results with an empty or unknown route go to W. Writers
+	// created by RouteWriter are closed at the end of Run, if they implement
+	// io.Closer, while writers in Routes are left open for the caller.
+	RouteF      RouteTransformerFunc
+	Routes      map[string]io.Writer
+	RouteWriter func(route string) (io.Writer, error)
+	// PerRecordTimeout limits the duration of a single transformer call. A
+	// record that times out yields an ErrRecordTimeout and the worker moves
+	// on to the next record. Only a ContextF can actually be interrupted, a
+	// timed out F keeps running in the background until it returns.
+	PerRecordTimeout time.Duration
+	// Workload allows to adjust worker setup to the kind of transformer.
+	Workload Workload
+	// PreFilter, if set, is evaluated on the reading goroutine and records
+	// for which it returns false are dropped before being dispatched to the
+	// workers. It should be cheap, as it runs serially.
+	PreFilter func([]byte) bool
+	// SplitFunc, if set, splits the input into records using a
+	// bufio.Scanner, instead of splitting on RecordSeparator. Tokens are
+	// passed to the transformer as they are, StripSeparator does not apply.
+	// Offsets then point to the start of the input consumed for a token,
+	// which includes any bytes the split function skipped before it.
+	SplitFunc bufio.SplitFunc
+	// MaxTokenSize is the maximum size of a token with SplitFunc, defaults
+	// to bufio.MaxScanTokenSize. A larger token fails the run with
+	// bufio.ErrTooLong. Records split on RecordSeparator have no limit.
+	MaxTokenSize int
+	// MaxOutputBytes stops processing once the output reached the given
+	// number of bytes. Results arriving after that are discarded, so the
+	// output exceeds the limit by at most one result. Zero means no limit.
+	MaxOutputBytes int64
+	// ReadBufferSize is the size of the read buffer, a larger buffer means
+	// fewer read calls on R. Defaults to the bufio default size.
+	ReadBufferSize int
+	// WriteBufferSize is the size of the output buffer of W and of each
+	// route, a larger buffer means fewer write calls, e.g. for many tiny
+	// results. Defaults to the bufio default size.
+	WriteBufferSize int
+	// MaxBytesPerSecond limits the rate at which R is read, e.g. to not
+	// saturate a shared file system. Zero means no limit.
+	MaxBytesPerSecond int64
+	// Decompress detects compressed input by its magic bytes and processes
+	// the decompressed records, see NewDecompressReader. Offsets then count
+	// decompressed bytes, so checkpoints and ResumeFrom cannot be used.
+	Decompress bool
+	R          io.Reader
+	// W receives all results from a single writer goroutine. Transformers
+	// must not write to W, Writers or routes themselves, as their output
+	// would interleave with the output of the writer goroutine, or block
+	// while the writer holds W, so the run may deadlock. This is not
+	// detected; return the output as the result instead.
+	W io.Writer
+	// F transforms a single record. The other transformers below, and
+	// RouteF, are used instead of F. Run fails with ErrTransformer, unless
+	// exactly one of them is set.
+	F TransformerFunc
+	// ContextF is a cancellation aware transformer, used instead of F, if
+	// set. Its context is cancelled after PerRecordTimeout.
+	ContextF ContextTransformerFunc
+	// Shared is passed to SharedF on every call. It is loaded once and
+	// shared by all workers, so it must be treated as read-only; the
+	// processor itself never modifies it.
+	Shared any
+	// SharedF is used instead of F, if set, receiving Shared with each
+	// record.
+	SharedF SharedTransformerFunc
+	// AuxF is used instead of F, if set. Its auxiliary output is written to
+	// AuxWriter by a separate writer goroutine, so results and auxiliary
+	// output are buffered independently and never interleave. Auxiliary
+	// output is discarded, if AuxWriter is nil.
+	AuxF      AuxTransformerFunc
+	AuxWriter io.Writer
+	// OffsetF is used instead of F, if set, receiving the offset of the
+	// first byte of the record in R. Offsets count all bytes read, including
+	// separators and records skipped as empty or by PreFilter, so offset and
+	// length of the raw record can be used to build an index into the input.
+	OffsetF OffsetTransformerFunc
+	// InPlaceF is used instead of F, if set, modifying the record buffer
+	// directly, which is then written as the result. This saves allocating
+	// a result per record. Every record has its own buffer, which is not
+	// reused after the record has been written.
+	InPlaceF InPlaceTransformerFunc
+	// Writers receive the same output as W, e.g. for writing to a file and
+	// to stdout. A writer is dropped after its first failed write, while
+	// the others still receive all output, and Run reports the errors of
+	// all failed writers at the end. The run only stops early, once all
+	// writers have failed.
+	Writers []io.Writer
+	// LengthPrefixSize, if set, writes each result as a frame, prefixed by
+	// its length in LengthPrefixSize bytes, which must be 1, 2, 4 or 8.
+	// Empty results are not written. The byte order defaults to big endian.
+	// Use record.NewLengthPrefixedSplitter to read frames.
+	LengthPrefixSize      int
+	LengthPrefixByteOrder binary.ByteOrder
+	// CompressBatches gzip compresses the results of each batch into a
+	// single block, written as a frame with a length prefix of
+	// LengthPrefixSize bytes, four by default. Blocks can be decompressed
+	// independently. Routes are ignored, all blocks are written to W, and
+	// RunN counts blocks instead of records.
+	CompressBatches bool
+	// PostWriteFunc, if set, wraps the output once per run, so all output
+	// passes through the returned writer serially, e.g. for counting or
+	// hashing the complete output.
+	PostWriteFunc func(w io.Writer) io.Writer
+	// TraceFunc, if set, is called for each record passed to the
+	// transformer, with timing information, e.g. to find slow records. It is
+	// called from the workers, concurrently.
+	TraceFunc func(Trace)
+	// SummaryFunc, if set, is called with the final statistics, after all
+	// results have been written, and its result is written last, e.g. a
+	// footer with counts and timing. It is not called in Identity mode.
+	SummaryFunc func(Stats) []byte
+	// WriteAt, if set, receives the transformer results instead of W, see
+	// WriteAtSink. Results are written by the workers, so RunN reports no
+	// records written. Verbatim records, like preserved comments, still go
+	// to W.
+	WriteAt *WriteAtSink
+	// Spill, if set, has each worker write its results to a temporary file,
+	// see SpillSink. The files are appended to W at the end of the run,
+	// before the summary. Routes, ReorderWindow and MaxOutputBytes do not
+	// apply to spilled results. Verbatim records, like preserved comments,
+	// still go to W directly.
+	Spill *SpillSink
+	// Pool, if set, runs the workers on the goroutines of a long-lived pool,
+	// which can be shared by processors, e.g. one per job. Only the workers
+	// use the pool, Run still starts a few goroutines for writing output.
+	Pool *Pool
+	// CheckpointFile, if set, receives the input offset just after the last
+	// record written to W, every CheckpointInterval and at the end of a run.
+	// W, AuxWriter, DeadLetterWriter and DroppedWriter are flushed before
+	// each checkpoint. Since output order must follow input order,
+	// checkpoints require a single worker, and they are not supported with
+	// Identity, Decompress or Spill. Use ReadCheckpoint and ResumeFrom to continue
+	// an interrupted run.
+	CheckpointFile string
+	// CheckpointInterval defaults to ten seconds.
+	CheckpointInterval time.Duration
+	// OutputOffset is the number of records, whose output has already been
+	// written by an interrupted run, e.g. the number of lines in a partial
+	// output with a one-to-one transformer. These records are still passed
+	// to the transformer, but their results are discarded. With
+	// SkipProcessed, they are skipped entirely. Records are counted after
+	// SkipEmptyLines and PreFilter. Since output order must follow input
+	// order, this requires a single worker. OutputOffset is an alternative
+	// to checkpoints for inputs that cannot seek; combined with ResumeFrom,
+	// records are counted from the resumed offset, and checkpoints still
+	// record the input offset.
+	OutputOffset  int64
+	SkipProcessed bool
+
+	// resumeOffset is the input offset set by ResumeFrom.
+	resumeOffset int64
+
+	// mu protects the fields below, which are only set during a run,
+	// except for resume.
+	mu sync.Mutex
+	// flushReq passes flush requests to the writer goroutine.
+	flushReq chan chan struct{}
+	// writerDone is closed, when the writer goroutine exits.
+	writerDone chan struct{}
+	// resume is closed by Resume, it is nil, if the processor is not paused.
+	resume chan struct{}
+	// stats are updated during a run.
+	stats Stats
+}
+
+// Stats are collected during a run.
+type Stats struct {
+	// Batches is the number of batches dispatched to the workers.
+	Batches int64
+	// Records is the number of records dispatched to the workers.
+	Records int64
+	// MinBatchSize and MaxBatchSize are the smallest and largest number of
+	// records in a dispatched batch. Batches are only partial at the end of
+	// the input, or if the run was aborted.
+	MinBatchSize int
+	MaxBatchSize int
+	// Written is the number of non-empty results written and Elapsed the
+	// duration of the run. Both are set at the end of a run.
+	Written int64
+	Elapsed time.Duration
+	// QueueWait is the time the reader spent waiting for a worker to accept
+	// a batch, which grows, if the workers are saturated. OutputWait is the
+	// time workers spent waiting for the writer to accept results, summed
+	// over all workers, which grows, if the writer is the bottleneck.
+	QueueWait  time.Duration
+	OutputWait time.Duration
+}
+
+// MeanBatchSize returns the average number of records per batch.
+func (s Stats) MeanBatchSize() float64 {
+	if s.Batches == 0 {
+		return 0
+	}
+	return float64(s.Records) / float64(s.Batches)
+}
+
+// Stats returns statistics about the current or last run. It is safe to call
+// Stats while Run is in progress.
+func (p *Processor) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// recordBatch updates the statistics for a dispatched batch.
+func (p *Processor) recordBatch(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stats.Batches == 0 || n < p.stats.MinBatchSize {
+		p.stats.MinBatchSize = n
+	}
+	if n > p.stats.MaxBatchSize {
+		p.stats.MaxBatchSize = n
+	}
+	p.stats.Batches++
+	p.stats.Records += int64(n)
+}
+
+// recordWait adds to the time spent waiting on the queue and output channels.
+func (p *Processor) recordWait(queue, output time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.QueueWait += queue
+	p.stats.OutputWait += output
 }
 
 // New is a preferred way to create a new parallel processor.
@@ -60,87 +490,1019 @@ func (p *Processor) RunWorkers(numWorkers int) error {
 	return p.Run()
 }
 
+// item is a single record, together with its position in the input.
+type item struct {
+	b      []byte
+	offset int64
+	end    int64 // offset just after the record
+	// verbatim records bypass the transformer.
+	verbatim bool
+	// suppress discards the result, see OutputOffset.
+	suppress bool
+	// seq is the number of the batch, if ReorderWindow is set.
+	seq int64
+	// index is the number of the record, if WriteAt is set.
+	index int64
+	// read is the time the record was read, if TraceFunc is set.
+	read time.Time
+}
+
+// result is the output for a record, together with the input offset just
+// after the record.
+type result struct {
+	b     []byte
+	end   int64
+	route string
+	// verbatim marks an unprocessed record, like a preserved comment.
+	verbatim bool
+}
+
+// outcome is the output of a single transformer call.
+type outcome struct {
+	out, aux []byte
+	route    string
+}
+
+// numTransformers returns the number of transformers set.
+func (p *Processor) numTransformers() int {
+	var n int
+	for _, set := range []bool{
+		p.F != nil,
+		p.InPlaceF != nil,
+		p.OffsetF != nil,
+		p.ContextF != nil,
+		p.SharedF != nil,
+		p.AuxF != nil,
+		p.RouteF != nil,
+	} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
+// transform applies the configured transformer to a single record, observing
+// PerRecordTimeout.
+func (p *Processor) transform(it item) (outcome, error) {
+	b := it.b
+	call := func(ctx context.Context) (o outcome, err error) {
+		switch {
+		case p.InPlaceF != nil:
+			p.InPlaceF(b)
+			o.out = b
+		case p.OffsetF != nil:
+			o.out, err = p.OffsetF(it.offset, b)
+		case p.ContextF != nil:
+			o.out, err = p.ContextF(ctx, b)
+		case p.SharedF != nil:
+			o.out, err = p.SharedF(p.Shared, b)
+		case p.AuxF != nil:
+			o.out, o.aux, err = p.AuxF(b)
+		case p.RouteF != nil:
+			o.out, o.route, err = p.RouteF(b)
+		default:
+			o.out, err = p.F(b)
+		}
+		return o, err
+	}
+	if p.PerRecordTimeout <= 0 {
+		return call(context.Background())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.PerRecordTimeout)
+	defer cancel()
+	type result struct {
+		o   outcome
+		err error
+	}
+	c := make(chan result, 1) // buffered, so an abandoned call can finish
+	go func() {
+		o, err := call(ctx)
+		c <- result{o, err}
+	}()
+	select {
+	case r := <-c:
+		if r.err != nil && ctx.Err() != nil {
+			return outcome{}, fmt.Errorf("%w after %v", ErrRecordTimeout, p.PerRecordTimeout)
+		}
+		return r.o, r.err
+	case <-ctx.Done():
+		return outcome{}, fmt.Errorf("%w after %v", ErrRecordTimeout, p.PerRecordTimeout)
+	}
+}
+
+// SingleWorkerOrdered configures the processor to use a single worker. This
+// guarantees that the output order matches the input order, at the cost of
+// parallelism. There is no reordering buffer involved, batches are simply
+// processed one after another.
+func (p *Processor) SingleWorkerOrdered() {
+	p.NumWorkers = 1
+}
+
+// numWorkers returns the number of workers to start, taking the workload
+// into account.
+func (p *Processor) numWorkers() int {
+	if n := runtime.GOMAXPROCS(0); p.Workload == CPUBound && p.NumWorkers > n {
+		return n
+	}
+	return p.NumWorkers
+}
+
+// recordReader returns a function, that returns the next record from R
+// together with its position, or io.EOF, if there are no more records or the
+// sentinel has been read.
+func (p *Processor) recordReader() func() (item, error) {
+	next := p.splitReader()
+	if p.StopOnSentinel == nil {
+		return next
+	}
+	var stopped bool
+	return func() (item, error) {
+		if stopped {
+			return item{}, io.EOF
+		}
+		it, err := next()
+		if err != nil {
+			return it, err
+		}
+		b := it.b
+		if p.SplitFunc == nil {
+			b = bytes.TrimSuffix(b, []byte{p.RecordSeparator})
+		}
+		if bytes.Equal(b, p.StopOnSentinel) {
+			stopped = true
+			return item{}, io.EOF
+		}
+		return it, nil
+	}
+}
+
+// splitReader returns a function, that returns the next record from R
+// together with its position, or io.EOF, if there are no more records.
+func (p *Processor) splitReader() func() (item, error) {
+	var (
+		r      = p.input()
+		br     = bufio.NewReader(r)
+		offset = p.resumeOffset
+	)
+	if p.ReadBufferSize > 0 {
+		br = bufio.NewReaderSize(r, p.ReadBufferSize)
+	}
+	if p.SplitFunc != nil {
+		var (
+			scanner     = bufio.NewScanner(br)
+			tokenOffset int64
+		)
+		scanner.Buffer(nil, p.maxTokenSize())
+		scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+			advance, token, err := p.SplitFunc(data, atEOF)
+			if token != nil {
+				tokenOffset = offset
+			}
+			offset += int64(advance)
+			return advance, token, err
+		})
+		return func() (item, error) {
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return item{}, err
+				}
+				return item{}, io.EOF
+			}
+			// The scanner reuses its buffer, so we need a copy.
+			b := make([]byte, len(scanner.Bytes()))
+			copy(b, scanner.Bytes())
+			return item{b: b, offset: tokenOffset, end: offset}, nil
+		}
+	}
+	var (
+		// detect is true until the first record has been read, whose line
+		// ending decides about crlf.
+		detect = p.AutoDetectLineEnding && p.RecordSeparator == '\n'
+		crlf   bool
+		// pending is a read error, returned after the data read with it.
+		pending error
+	)
+	return func() (item, error) {
+		if pending != nil {
+			return item{}, pending
+		}
+		b, err := br.ReadBytes(p.RecordSeparator)
+		switch {
+		case err == io.EOF && len(b) == 0:
+			return item{}, io.EOF
+		case err != nil && err != io.EOF:
+			if len(b) == 0 {
+				return item{}, err
+			}
+			pending = err
+		}
+		// The last record may lack a trailing separator.
+		start := offset
+		offset += int64(len(b))
+		if detect {
+			crlf, detect = bytes.HasSuffix(b, []byte("\r\n")), false
+		}
+		if crlf {
+			b = trimCR(b)
+		}
+		return item{b: p.normalizeSeparator(b), offset: start, end: offset}, nil
+	}
+}
+
+// keep reports whether a record should be processed, according to
+// SkipEmptyLines or SkipFunc, PreFilter and PreserveComments. Comments are marked as
+// verbatim.
+func (p *Processor) keep(it *item) bool {
+	if p.PreserveComments && p.isComment(it.b) {
+		it.verbatim = true
+		return true
+	}
+	if p.SkipFunc != nil {
+		if p.SkipFunc(it.b) {
+			return false
+		}
+	} else if len(bytes.TrimSpace(it.b)) == 0 && p.SkipEmptyLines {
+		return false
+	}
+	return p.PreFilter == nil || p.PreFilter(it.b)
+}
+
+// runIdentity copies records to the sink in the reading goroutine, without
+// any workers. Pause takes effect every BatchSize records read.
+func (p *Processor) runIdentity(s sink) (int64, error) {
+	var (
+		next    = p.recordReader()
+		read    int
+		count   int64
+		written int64
+		skipped int64
+		started = time.Now()
+		// mu guards the sink, which Flush accesses from its own goroutine.
+		mu         sync.Mutex
+		flushErr   firstError
+		flushReq   = make(chan chan struct{})
+		writerDone = make(chan struct{})
+	)
+	p.mu.Lock()
+	p.flushReq, p.writerDone = flushReq, writerDone
+	p.stats = Stats{}
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.flushReq, p.writerDone = nil, nil
+		p.stats.Written = count
+		p.stats.Elapsed = time.Since(started)
+		p.mu.Unlock()
+		close(writerDone)
+	}()
+	go func() {
+		for {
+			select {
+			case ack := <-flushReq:
+				mu.Lock()
+				if err := s.Flush(); err != nil {
+					flushErr.set(err)
+				}
+				mu.Unlock()
+				close(ack)
+			case <-writerDone:
+				return
+			}
+		}
+	}()
+	// flush writes the remaining output, once the sink is no longer shared.
+	flush := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return errors.Join(flushErr.get(), s.Flush())
+	}
+	for {
+		if read%max(p.BatchSize, 1) == 0 {
+			p.waitResume()
+		}
+		read++
+		it, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Records read so far are still written.
+			if ferr := flush(); ferr != nil {
+				return count, errors.Join(err, ferr)
+			}
+			return count, err
+		}
+		if !p.keep(&it) {
+			continue
+		}
+		if skipped < p.OutputOffset {
+			skipped++
+			continue
+		}
+		mu.Lock()
+		n, err := s.Write(it.b)
+		mu.Unlock()
+		if err != nil {
+			return count, err
+		}
+		count++
+		written += int64(n)
+		if p.MaxOutputBytes > 0 && written >= p.MaxOutputBytes {
+			break
+		}
+	}
+	return count, flush()
+}
+
+// sortResults sorts the results of a batch with SortBatchFunc. Only the last
+// result then marks the end of the batch, all other results point to its
+// start, so checkpoints never skip unwritten records.
+func (p *Processor) sortResults(rs []result, start int64) {
+	end := rs[len(rs)-1].end
+	sort.SliceStable(rs, func(i, j int) bool {
+		return p.SortBatchFunc(rs[i].b, rs[j].b)
+	})
+	for i := range rs {
+		rs[i].end = start
+	}
+	rs[len(rs)-1].end = end
+}
+
+// maxTokenSize returns the maximum token size with SplitFunc.
+func (p *Processor) maxTokenSize() int {
+	if p.MaxTokenSize > 0 {
+		return p.MaxTokenSize
+	}
+	return bufio.MaxScanTokenSize
+}
+
+// logger returns the configured logger or the standard logger.
+func (p *Processor) logger() Logger {
+	if p.Logger == nil {
+		return log.Default()
+	}
+	return p.Logger
+}
+
+// isComment reports whether b is a blank line or a comment.
+func (p *Processor) isComment(b []byte) bool {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return true
+	}
+	prefix := p.CommentPrefix
+	if len(prefix) == 0 {
+		prefix = []byte("#")
+	}
+	return bytes.HasPrefix(b, prefix)
+}
+
+// deadLetter formats a failed record for the DeadLetterWriter.
+func (p *Processor) deadLetter(b []byte, err error) []byte {
+	if !p.DeadLetterIncludeError {
+		return b
+	}
+	b = bytes.TrimSuffix(b, []byte{p.RecordSeparator})
+	return []byte(fmt.Sprintf("%s\t%s\n", b, err))
+}
+
+// drain writes everything received on c to w until c is closed, then signals
+// done. Data is discarded, if w is nil. Requests on flushReq are acknowledged
+// by closing the request channel, once w has been flushed.
+func drain(w io.Writer, c chan []byte, flushReq chan chan struct{}, wErr *firstError, done chan bool) {
+	var bw *bufio.Writer
+	if w != nil {
+		bw = bufio.NewWriter(w)
+	}
+loop:
+	for {
+		select {
+		case ack := <-flushReq:
+			if bw != nil {
+				if err := bw.Flush(); err != nil {
+					wErr.set(err)
+				}
+			}
+			close(ack)
+		case b, ok := <-c:
+			if !ok {
+				break loop
+			}
+			if bw == nil {
+				continue
+			}
+			if _, err := bw.Write(b); err != nil {
+				wErr.set(err)
+			}
+		}
+	}
+	if bw != nil {
+		if err := bw.Flush(); err != nil {
+			wErr.set(err)
+		}
+	}
+	done <- true
+}
+
+// isClosed reports whether channel c has been closed.
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// partition returns the partition of key, between zero and n-1.
+func partition(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+// trimCR removes a carriage return before a trailing newline or at the end of
+// the last line.
+func trimCR(b []byte) []byte {
+	switch {
+	case bytes.HasSuffix(b, []byte("\r\n")):
+		b[len(b)-2] = '\n'
+		return b[:len(b)-1]
+	case bytes.HasSuffix(b, []byte("\r")):
+		return b[:len(b)-1]
+	default:
+		return b
+	}
+}
+
+// normalizeSeparator adds or removes the trailing record separator, according
+// to StripSeparator.
+func (p *Processor) normalizeSeparator(b []byte) []byte {
+	hasSeparator := len(b) > 0 && b[len(b)-1] == p.RecordSeparator
+	switch {
+	case !p.StripSeparator && !hasSeparator:
+		return append(b, p.RecordSeparator)
+	case p.StripSeparator && hasSeparator:
+		return b[:len(b)-1]
+	default:
+		return b
+	}
+}
+
+// Flush forces buffered output to be written while Run is in progress, e.g.
+// for interactive use. Only results that already reached the writer are
+// flushed, results still being computed are not waited for. Flush is safe for
+// concurrent use and does nothing, if the processor is not running.
+func (p *Processor) Flush() {
+	p.mu.Lock()
+	flushReq, writerDone := p.flushReq, p.writerDone
+	p.mu.Unlock()
+	if flushReq == nil {
+		return
+	}
+	ack := make(chan struct{})
+	select {
+	case flushReq <- ack:
+		<-ack
+	case <-writerDone:
+	}
+}
+
+// Pause stops dispatching new batches to the workers, until Resume is called.
+// Batches already dispatched are still processed and written. Pause and
+// Resume are safe for concurrent use, a processor paused before Run starts
+// paused.
+func (p *Processor) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resume == nil {
+		p.resume = make(chan struct{})
+	}
+}
+
+// Resume continues dispatching batches after Pause.
+func (p *Processor) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resume != nil {
+		close(p.resume)
+		p.resume = nil
+	}
+}
+
+// waitResume blocks while the processor is paused.
+func (p *Processor) waitResume() {
+	p.mu.Lock()
+	resume := p.resume
+	p.mu.Unlock()
+	if resume != nil {
+		<-resume
+	}
+}
+
+// sink receives results in the writer goroutine.
+type sink interface {
+	io.Writer
+	Flush() error
+}
+
 // Run starts the workers, crunching through the input.
 func (p *Processor) Run() error {
+	_, err := p.RunN()
+	return err
+}
+
+// RunN is like Run, but additionally returns the number of records written,
+// that is the number of non-empty results.
+func (p *Processor) RunN() (int64, error) {
+	w := p.output()
+	tee, _ := w.(*teeWriter)
+	if p.PostWriteFunc != nil {
+		w = p.PostWriteFunc(w)
+	}
+	var s sink = bufio.NewWriterSize(w, p.WriteBufferSize)
+	if size := p.lengthPrefixSize(); size > 0 {
+		fs, err := newFrameSink(s, size, p.LengthPrefixByteOrder)
+		if err != nil {
+			return 0, err
+		}
+		s = fs
+	}
+	n, err := p.run(s)
+	if err == nil && tee != nil {
+		// Failed writers do not stop the run, they are reported at the end.
+		err = tee.err()
+	}
+	return n, err
+}
+
+// run processes the input and passes all results to the given sink.
+func (p *Processor) run(s sink) (int64, error) {
+	if p.NumWorkers < 1 || p.NumWorkers > MaxWorkers {
+		return 0, ErrInvalidWorkers
+	}
+	if p.CheckpointFile != "" && p.NumWorkers != 1 {
+		return 0, ErrCheckpointWorkers
+	}
+	if n := p.numTransformers(); n > 1 || (n == 0 && !p.Identity) {
+		return 0, ErrTransformer
+	}
+	if p.CheckpointFile != "" && p.Identity {
+		return 0, ErrCheckpointIdentity
+	}
+	if p.Decompress && (p.CheckpointFile != "" || p.resumeOffset > 0) {
+		return 0, ErrCheckpointDecompress
+	}
+	if p.CheckpointFile != "" && p.Spill != nil {
+		return 0, ErrCheckpointSpill
+	}
+	if p.Identity {
+		return p.runIdentity(s)
+	}
+	if p.OutputOffset > 0 && p.NumWorkers != 1 {
+		return 0, ErrOutputOffsetWorkers
+	}
 	// wErr signals a worker or writer error. If an error occurs, the items in
-	// the queue are still process, just no items are added to the queue. There
-	// is only one way to toggle this, from false to true, so we don't care
-	// about synchronisation.
-	var wErr error
+	// the queue are still process, just no items are added to the queue.
+	var wErr firstError
+	// errs collects transformer errors, if MaxErrors is set.
+	var errs errorList
+	// ordered receives the results of each batch, if ReorderWindow is set.
+	var ordered chan batchResult
+	if p.ReorderWindow > 0 && p.Spill == nil {
+		ordered = make(chan batchResult)
+	}
+	// spills are the temporary files of the workers, if Spill is set.
+	var spills []*spillFile
+	if p.Spill != nil {
+		var err error
+		if spills, err = p.Spill.create(p.numWorkers()); err != nil {
+			return 0, err
+		}
+		defer func() {
+			if err := removeSpillFiles(spills); err != nil {
+				p.logger().Printf("removing spill files: %v", err)
+			}
+		}()
+	}
 	// worker takes []byte batches from a channel queue, executes f and sends
-	// the result to the out channel.
-	worker := func(queue chan [][]byte, out chan []byte, f TransformerFunc, wg *sync.WaitGroup) {
-		defer wg.Done()
+	// the result to the out channel. Errors are tagged with the worker id.
+	worker := func(id int, queue chan []item, out chan result, auxC, deadC, droppedC chan []byte) {
+		if p.Workload == CPUBound {
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+		}
+		// batched collects the results of a batch, if SortBatchFunc,
+		// CompressBatches or ReorderWindow is set.
+		var (
+			batched []result
+			collect = p.SortBatchFunc != nil || p.CompressBatches || ordered != nil
+			// wait is the time spent sending the results of a batch.
+			wait time.Duration
+		)
+		// emit passes a result on to the writer or the spill file.
+		emit := func(r result) {
+			if spills != nil && !r.verbatim {
+				if err := spills[id].write(r.b); err != nil {
+					wErr.set(err)
+				}
+				return
+			}
+			started := time.Now()
+			out <- r
+			wait += time.Since(started)
+		}
+		send := func(r result) {
+			if collect {
+				batched = append(batched, r)
+			} else {
+				emit(r)
+			}
+		}
 		for batch := range queue {
-			for _, b := range batch {
-				r, err := f(b)
+			for _, it := range batch {
+				if it.verbatim {
+					b := it.b
+					if it.suppress {
+						b = nil
+					}
+					send(result{b: b, end: it.end, verbatim: true})
+					continue
+				}
+				if p.MaxErrors > 0 && wErr.get() != nil {
+					continue
+				}
+				var started time.Time
+				if p.TraceFunc != nil {
+					started = time.Now()
+				}
+				o, err := p.transform(it)
+				if p.TraceFunc != nil {
+					p.TraceFunc(Trace{
+						Offset: it.offset,
+						Worker: id,
+						Read:   it.read,
+						Start:  started,
+						End:    time.Now(),
+						Err:    err,
+					})
+				}
+				r := o.out
 				if err != nil {
-					wErr = err
+					if p.DeadLetterWriter != nil {
+						deadC <- p.deadLetter(it.b, err)
+					}
+					switch {
+					case p.PassthroughOnError:
+						r = it.b
+					case p.DeadLetterWriter != nil:
+						r = nil
+					case p.MaxErrors > 0:
+						r = nil
+						if errs.add(fmt.Errorf("worker %d: %w", id, err)) > p.MaxErrors {
+							wErr.set(ErrTooManyErrors)
+						}
+					default:
+						wErr.set(fmt.Errorf("worker %d: %w", id, err))
+					}
+				} else if r == nil && p.NilMeansPassthrough {
+					r = it.b
+				} else if len(r) == 0 && p.DroppedWriter != nil && !it.suppress {
+					droppedC <- it.b
 				}
-				out <- r
+				// Auxiliary output is sent before the result, so it has
+				// reached its writer before a checkpoint includes the record.
+				if len(o.aux) > 0 && p.AuxWriter != nil {
+					auxC <- o.aux
+				}
+				if it.suppress {
+					r = nil
+				}
+				if p.WriteAt != nil {
+					if err == nil && !it.suppress {
+						if err := p.WriteAt.write(it.index, r); err != nil {
+							wErr.set(err)
+						}
+					}
+					if p.CheckpointFile != "" {
+						// An empty result advances the checkpoint.
+						send(result{end: it.end})
+					}
+					continue
+				}
+				send(result{b: r, end: it.end, route: o.route})
 			}
+			if len(batched) > 0 {
+				if p.SortBatchFunc != nil {
+					p.sortResults(batched, batch[0].offset)
+				}
+				if p.CompressBatches {
+					r, err := compressResults(batched)
+					if err != nil {
+						wErr.set(err)
+						r = result{end: r.end}
+					}
+					batched = append(batched[:0], r)
+				}
+			}
+			started := time.Now()
+			if ordered != nil {
+				// Empty batches are passed on as well, so the writer does not
+				// wait for them.
+				ordered <- batchResult{seq: batch[0].seq, rs: batched}
+				batched = nil
+			} else {
+				for _, r := range batched {
+					emit(r)
+				}
+				batched = batched[:0]
+			}
+			p.recordWait(0, wait+time.Since(started))
+			wait = 0
 		}
 	}
-	// writer buffers writes.
-	writer := func(w io.Writer, bc chan []byte, done chan bool) {
-		bw := bufio.NewWriter(w)
-		for b := range bc {
-			if _, err := bw.Write(b); err != nil {
-				wErr = err
+	// stop is closed by the writer, once MaxOutputBytes is reached, to signal
+	// the reader to stop dispatching batches.
+	var (
+		stop     = make(chan struct{})
+		stopOnce sync.Once
+	)
+	// count is the number of non-empty results written by the writer.
+	var count int64
+	started := time.Now()
+	// drainFlushReqs allow the writer to flush auxiliary output, dead
+	// letters and dropped records before a checkpoint.
+	drainFlushReqs := []chan chan struct{}{
+		make(chan chan struct{}),
+		make(chan chan struct{}),
+		make(chan chan struct{}),
+	}
+	// writer passes results to the sink.
+	writer := func(s sink, rc chan result, flushReq chan chan struct{}, done chan bool) {
+		// Routed results are written to their own writers, anything else to
+		// the sink, so flushing the router flushes the sink as well.
+		rt := &router{sink: s, p: p}
+		s = rt
+		var (
+			written int64
+			limited = p.MaxOutputBytes > 0
+			// last is the input offset after the last record written.
+			last = p.resumeOffset
+			tick <-chan time.Time
+		)
+		// checkpoint flushes auxiliary output, dead letters and dropped
+		// records first, so a resumed run does not lose any of them.
+		checkpoint := func() {
+			for _, req := range drainFlushReqs {
+				ack := make(chan struct{})
+				req <- ack
+				<-ack
+			}
+			if err := p.checkpoint(s, last); err != nil {
+				wErr.set(err)
 			}
 		}
-		if err := bw.Flush(); err != nil {
-			wErr = err
+		if p.CheckpointFile != "" {
+			ticker := time.NewTicker(p.checkpointInterval())
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+	loop:
+		for {
+			select {
+			case ack := <-flushReq:
+				if err := s.Flush(); err != nil {
+					wErr.set(err)
+				}
+				close(ack)
+			case <-tick:
+				checkpoint()
+			case r, ok := <-rc:
+				if !ok {
+					break loop
+				}
+				if limited && written >= p.MaxOutputBytes {
+					continue
+				}
+				n, err := rt.writeRoute(r.route, r.b)
+				if err != nil {
+					wErr.set(err)
+				} else {
+					last = r.end
+					if len(r.b) > 0 {
+						count++
+					}
+				}
+				written += int64(n)
+				if limited && written >= p.MaxOutputBytes {
+					stopOnce.Do(func() { close(stop) })
+				}
+			}
+		}
+		// Workers have finished, once rc is closed.
+		for _, f := range spills {
+			if err := f.copyTo(s); err != nil {
+				wErr.set(err)
+			}
+			count += f.n
+		}
+		p.mu.Lock()
+		p.stats.Written = count
+		p.stats.Elapsed = time.Since(started)
+		stats := p.stats
+		p.mu.Unlock()
+		if p.SummaryFunc != nil {
+			if _, err := s.Write(p.SummaryFunc(stats)); err != nil {
+				wErr.set(err)
+			}
+		}
+		if p.CheckpointFile != "" {
+			checkpoint()
+		} else if err := s.Flush(); err != nil {
+			wErr.set(err)
+		}
+		if err := rt.close(); err != nil {
+			wErr.set(err)
 		}
 		done <- true
 	}
 	var (
-		queue   = make(chan [][]byte)
-		out     = make(chan []byte)
+		out     = make(chan result)
+		auxC    = make(chan []byte)
+		deadC   = make(chan []byte)
 		done    = make(chan bool)
-		total   int64
-		started = time.Now()
-		wg      sync.WaitGroup
+		auxDone = make(chan bool)
+		// deadDone signals completion of writing dead letters
+		deadDone = make(chan bool)
+		droppedC = make(chan []byte)
+		// droppedDone signals completion of writing dropped records
+		droppedDone = make(chan bool)
+		total       int64
+		wg          sync.WaitGroup
+		// flushReq and writerDone allow Flush to talk to the writer.
+		flushReq   = make(chan chan struct{})
+		writerDone = make(chan struct{})
 	)
-	go writer(p.W, out, done)
-	for i := 0; i < p.NumWorkers; i++ {
+	p.mu.Lock()
+	p.flushReq, p.writerDone = flushReq, writerDone
+	p.stats = Stats{}
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.flushReq, p.writerDone = nil, nil
+		p.mu.Unlock()
+	}()
+	go func() {
+		defer close(writerDone)
+		writer(s, out, flushReq, done)
+	}()
+	// Auxiliary output, dead letters and dropped records have their own
+	// buffers and no output limit.
+	go drain(p.AuxWriter, auxC, drainFlushReqs[0], &wErr, auxDone)
+	go drain(p.DeadLetterWriter, deadC, drainFlushReqs[1], &wErr, deadDone)
+	go drain(p.DroppedWriter, droppedC, drainFlushReqs[2], &wErr, droppedDone)
+	reorderDone := make(chan bool)
+	if ordered != nil {
+		go func() {
+			reorder(ordered, out, p.ReorderWindow)
+			close(reorderDone)
+		}()
+	}
+	// queues are shared by all workers, or with Affinity, there is one
+	// queue per worker.
+	queues := []chan []item{make(chan []item)}
+	if p.Affinity || p.KeyFunc != nil {
+		for i := 1; i < p.numWorkers(); i++ {
+			queues = append(queues, make(chan []item))
+		}
+	}
+	for i := 0; i < p.numWorkers(); i++ {
 		wg.Add(1)
-		go worker(queue, out, p.F, &wg)
+		queue := queues[i%len(queues)]
+		if p.Pool != nil {
+			p.Pool.run(func() { worker(i, queue, out, auxC, deadC, droppedC) }, wg.Done)
+		} else {
+			go func() {
+				defer wg.Done()
+				worker(i, queue, out, auxC, deadC, droppedC)
+			}()
+		}
+	}
+	// dispatch passes batches to the queues in turn.
+	var dispatched int
+	dispatch := func(k int, batch []item) {
+		p.recordBatch(len(batch))
+		if ordered != nil {
+			for i := range batch {
+				batch[i].seq = int64(dispatched)
+			}
+		}
+		if p.KeyFunc == nil {
+			k = dispatched % len(queues)
+		}
+		started := time.Now()
+		queues[k] <- batch
+		p.recordWait(time.Since(started), 0)
+		dispatched++
+	}
+	// size is the current batch size, which grows up to BatchSize, if
+	// AdaptiveBatchSize is set.
+	size := p.BatchSize
+	if p.AdaptiveBatchSize {
+		size = min(initialAdaptiveBatchSize, p.BatchSize)
 	}
-	batch := NewBytesBatchCapacity(p.BatchSize)
-	br := bufio.NewReader(p.R)
+	// parts holds a batch per worker, if KeyFunc is set, and a single batch
+	// otherwise.
+	parts := make([][]item, 1)
+	if p.KeyFunc != nil {
+		parts = make([][]item, len(queues))
+	}
+	next := p.recordReader()
+	// skipped is the number of records counted towards OutputOffset, index
+	// the number of records read.
+	var skipped, index int64
+	// readErr is a read error, which stops reading, but records read so far
+	// are still processed and written.
+	var readErr error
 	for {
-		b, err := br.ReadBytes(p.RecordSeparator)
+		it, err := next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return err
+			readErr = err
+			break
 		}
-		if len(bytes.TrimSpace(b)) == 0 && p.SkipEmptyLines {
+		if !p.keep(&it) {
 			continue
 		}
-		batch.Add(b)
-		if batch.Size() == p.BatchSize {
+		// Verbatim records are written to W, so they take no place in the
+		// output of WriteAt.
+		if !it.verbatim {
+			it.index = index
+			index++
+		}
+		if skipped < p.OutputOffset {
+			skipped++
+			if p.SkipProcessed {
+				continue
+			}
+			it.suppress = true
+		}
+		if p.TraceFunc != nil {
+			it.read = time.Now()
+		}
+		var k int
+		if p.KeyFunc != nil {
+			k = partition(p.KeyFunc(it.b), len(parts))
+		}
+		parts[k] = append(parts[k], it)
+		if batch := parts[k]; len(batch) >= size {
 			if p.Verbose {
-				log.Printf("parallel: dispatched %d lines (%0.2f lines/s)",
+				p.logger().Printf("parallel: dispatched %d lines (%0.2f lines/s)",
 					total, float64(total)/time.Since(started).Seconds())
 			}
-			total += int64(p.BatchSize)
+			total += int64(len(batch))
 			// To avoid checking on each loop, we only check for worker or
 			// write errors here.
-			if wErr != nil {
+			if wErr.get() != nil || isClosed(stop) {
 				break
 			}
-			queue <- batch.Slice()
-			batch.Reset()
+			p.waitResume()
+			dispatch(k, batch)
+			size = min(2*size, p.BatchSize)
+			parts[k] = make([]item, 0, size)
+		}
+	}
+	for k, batch := range parts {
+		if !isClosed(stop) && len(batch) > 0 {
+			p.waitResume()
+			dispatch(k, batch)
 		}
 	}
-	queue <- batch.Slice()
-	batch.Reset()
-	close(queue)
+	for _, queue := range queues {
+		close(queue)
+	}
 	wg.Wait()
+	if ordered != nil {
+		close(ordered)
+		<-reorderDone
+	}
 	close(out)
+	// The writer may still flush the other outputs for a checkpoint, so
+	// they are closed after the writer is done.
 	<-done
-	return wErr
+	close(auxC)
+	close(deadC)
+	close(droppedC)
+	<-auxDone
+	<-deadDone
+	<-droppedDone
+	if readErr != nil {
+		return count, readErr
+	}
+	if err := wErr.get(); err == ErrTooManyErrors {
+		return count, errors.Join(err, errs.join())
+	}
+	return count, wErr.get()
 }
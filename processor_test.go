@@ -1,11 +1,24 @@
 package parallel
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/miku/parallel/record"
 )
 
 var errFake1 = errors.New("fake error #1")
@@ -118,3 +131,1884 @@ func TestSimple(t *testing.T) {
 		}
 	}
 }
+
+func TestPassthroughOnError(t *testing.T) {
+	input := `{"name": "a"}
+{"name": 
+{"name": "b"}
+not json
+`
+	expected := `{"name":"A"}
+{"name": 
+{"name":"B"}
+not json
+`
+	var buf bytes.Buffer
+	p := NewProcessor(strings.NewReader(input), &buf, func(b []byte) ([]byte, error) {
+		var doc struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(b, &doc); err != nil {
+			return nil, err
+		}
+		doc.Name = strings.ToUpper(doc.Name)
+		v, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		return append(v, '\n'), nil
+	})
+	p.PassthroughOnError = true
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if !LinesEqual(buf.String(), expected) {
+		t.Errorf("p.Run: got %v, want %v", buf.String(), expected)
+	}
+}
+
+func TestPerRecordTimeout(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		cancelled int
+	)
+	p := NewProcessor(strings.NewReader("a\nb\nc\n"), io.Discard, nil)
+	p.PerRecordTimeout = 10 * time.Millisecond
+	p.ContextF = func(ctx context.Context, b []byte) ([]byte, error) {
+		select {
+		case <-time.After(5 * time.Second):
+			return b, nil
+		case <-ctx.Done():
+			mu.Lock()
+			cancelled++
+			mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+	started := time.Now()
+	err := p.Run()
+	if !errors.Is(err, ErrRecordTimeout) {
+		t.Fatalf("p.Run: got %v, want %v", err, ErrRecordTimeout)
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Fatalf("p.Run: took %v, transformer not interrupted", elapsed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if cancelled != 3 {
+		t.Fatalf("got %d cancelled records, want 3", cancelled)
+	}
+}
+
+func TestPerRecordTimeoutNotExceeded(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewProcessor(strings.NewReader("a\nb\n"), &buf, ToTransformerFunc(bytes.ToUpper))
+	p.PerRecordTimeout = time.Second
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if !LinesEqual(buf.String(), "A\nB\n") {
+		t.Errorf("p.Run: got %v, want %v", buf.String(), "A\nB\n")
+	}
+}
+
+func TestSingleWorkerOrdered(t *testing.T) {
+	var input, expected strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&input, "line %d\n", i)
+		fmt.Fprintf(&expected, "LINE %d\n", i)
+	}
+	var buf bytes.Buffer
+	p := NewProcessor(strings.NewReader(input.String()), &buf, ToTransformerFunc(bytes.ToUpper))
+	p.BatchSize = 7
+	p.SingleWorkerOrdered()
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if buf.String() != expected.String() {
+		t.Errorf("p.Run: output order differs from input order")
+	}
+}
+
+// countingReader counts the number of Read calls.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	r.n++
+	return r.r.Read(p)
+}
+
+func BenchmarkReadBufferSize(b *testing.B) {
+	data := bytes.Repeat([]byte("a moderately long line of input data\n"), 100000)
+	for _, size := range []int{0, 65536, 1048576} {
+		b.Run(fmt.Sprintf("size-%d", size), func(b *testing.B) {
+			var reads int
+			for i := 0; i < b.N; i++ {
+				r := &countingReader{r: bytes.NewReader(data)}
+				p := NewProcessor(r, io.Discard, func(b []byte) ([]byte, error) {
+					return b, nil
+				})
+				p.ReadBufferSize = size
+				if err := p.Run(); err != nil {
+					b.Fatal(err)
+				}
+				reads += r.n
+			}
+			b.ReportMetric(float64(reads)/float64(b.N), "reads/op")
+		})
+	}
+}
+
+func TestInvalidWorkers(t *testing.T) {
+	for _, n := range []int{0, -1, MaxWorkers + 1} {
+		p := NewProcessor(strings.NewReader("a\n"), io.Discard, ToTransformerFunc(bytes.ToUpper))
+		p.NumWorkers = n
+		if err := p.Run(); err != ErrInvalidWorkers {
+			t.Errorf("p.Run with %d workers: got %v, want %v", n, err, ErrInvalidWorkers)
+		}
+		w := NewWindowProcessor(strings.NewReader("a\n"), io.Discard, 1, 1, nil)
+		w.NumWorkers = n
+		if err := w.Run(); err != ErrInvalidWorkers {
+			t.Errorf("w.Run with %d workers: got %v, want %v", n, err, ErrInvalidWorkers)
+		}
+	}
+}
+
+func TestShared(t *testing.T) {
+	lookup := make(map[string]string)
+	var input, expected strings.Builder
+	for i := 0; i < 1000; i++ {
+		lookup[fmt.Sprintf("k%d", i)] = fmt.Sprintf("v%d", i)
+		fmt.Fprintf(&input, "k%d\n", i)
+		fmt.Fprintf(&expected, "k%d\tv%d\n", i, i)
+	}
+	var buf bytes.Buffer
+	p := NewProcessor(strings.NewReader(input.String()), &buf, nil)
+	p.BatchSize = 1
+	p.NumWorkers = 8
+	p.Shared = lookup
+	p.SharedF = func(shared any, b []byte) ([]byte, error) {
+		key := string(bytes.TrimSpace(b))
+		v, ok := shared.(map[string]string)[key]
+		if !ok {
+			return nil, fmt.Errorf("key not found: %s", key)
+		}
+		return []byte(key + "\t" + v + "\n"), nil
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if !LinesEqual(buf.String(), expected.String()) {
+		t.Errorf("p.Run: got %v, want %v", buf.String(), expected.String())
+	}
+}
+
+func TestStripSeparator(t *testing.T) {
+	var cases = []struct {
+		about          string
+		input          string
+		stripSeparator bool
+		expected       []string
+	}{
+		{
+			about:          `Separator kept, added to the last record.`,
+			input:          "a\nb\nc",
+			stripSeparator: false,
+			expected:       []string{"a\n", "b\n", "c\n"},
+		},
+		{
+			about:          `Separator kept, last record terminated.`,
+			input:          "a\nb\nc\n",
+			stripSeparator: false,
+			expected:       []string{"a\n", "b\n", "c\n"},
+		},
+		{
+			about:          `Separator stripped, last record unterminated.`,
+			input:          "a\nb\nc",
+			stripSeparator: true,
+			expected:       []string{"a", "b", "c"},
+		},
+		{
+			about:          `Separator stripped, last record terminated.`,
+			input:          "a\nb\nc\n",
+			stripSeparator: true,
+			expected:       []string{"a", "b", "c"},
+		},
+	}
+	for _, c := range cases {
+		var (
+			mu       sync.Mutex
+			received []string
+		)
+		p := NewProcessor(strings.NewReader(c.input), io.Discard, func(b []byte) ([]byte, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			received = append(received, string(b))
+			return nil, nil
+		})
+		p.StripSeparator = c.stripSeparator
+		if err := p.Run(); err != nil {
+			t.Fatalf("[%s] p.Run: got %v, want nil", c.about, err)
+		}
+		sort.Strings(received)
+		if !reflect.DeepEqual(received, c.expected) {
+			t.Errorf("[%s] got %q, want %q", c.about, received, c.expected)
+		}
+	}
+}
+
+func TestProcessorLiteral(t *testing.T) {
+	// A processor created without NewProcessor keeps separators.
+	var buf bytes.Buffer
+	p := &Processor{
+		BatchSize:       10,
+		RecordSeparator: '\n',
+		NumWorkers:      1,
+		R:               strings.NewReader("a\nb\n"),
+		W:               &buf,
+		F: func(b []byte) ([]byte, error) {
+			return b, nil
+		},
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if buf.String() != "a\nb\n" {
+		t.Errorf("got %q, want %q", buf.String(), "a\nb\n")
+	}
+}
+
+func TestMaxOutputBytes(t *testing.T) {
+	var (
+		record = "0123456789\n"
+		n      = 10000
+		calls  int64
+		buf    bytes.Buffer
+	)
+	p := NewProcessor(strings.NewReader(strings.Repeat(record, n)), &buf, func(b []byte) ([]byte, error) {
+		atomic.AddInt64(&calls, 1)
+		return b, nil
+	})
+	p.BatchSize = 10
+	p.NumWorkers = 2
+	p.MaxOutputBytes = 1000
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if buf.Len() < 1000 || buf.Len() > 1000+len(record) {
+		t.Fatalf("got %d bytes, want between 1000 and %d", buf.Len(), 1000+len(record))
+	}
+	if c := atomic.LoadInt64(&calls); c == int64(n) {
+		t.Fatalf("processed all %d records, want early stop", c)
+	}
+}
+
+func TestAuxWriter(t *testing.T) {
+	var out, aux bytes.Buffer
+	p := NewProcessor(strings.NewReader("1\nx\n2\ny\n3\n"), &out, nil)
+	p.AuxF = func(b []byte) ([]byte, []byte, error) {
+		if _, err := strconv.Atoi(string(bytes.TrimSpace(b))); err != nil {
+			return nil, []byte(fmt.Sprintf("skipping %q\n", bytes.TrimSpace(b))), nil
+		}
+		return b, nil, nil
+	}
+	p.AuxWriter = &aux
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if !LinesEqual(out.String(), "1\n2\n3\n") {
+		t.Errorf("p.Run: got %q, want %q", out.String(), "1\n2\n3\n")
+	}
+	if !LinesEqual(aux.String(), "skipping \"x\"\nskipping \"y\"\n") {
+		t.Errorf("p.Run: got %q, want %q", aux.String(), "skipping \"x\"\nskipping \"y\"\n")
+	}
+}
+
+func TestDeadLetterWriter(t *testing.T) {
+	var cases = []struct {
+		about        string
+		includeError bool
+		passthrough  bool
+		out          string
+		dead         string
+	}{
+		{
+			about: `Failed records go to the dead letter writer only.`,
+			out:   "1\n2\n3\n",
+			dead:  "x\ny\n",
+		},
+		{
+			about:        `Error text is appended.`,
+			includeError: true,
+			out:          "1\n2\n3\n",
+			dead:         "x\tnot a number\ny\tnot a number\n",
+		},
+		{
+			about:       `Passthrough writes failed records to both writers.`,
+			passthrough: true,
+			out:         "1\nx\n2\ny\n3\n",
+			dead:        "x\ny\n",
+		},
+	}
+	for _, c := range cases {
+		var out, dead bytes.Buffer
+		p := NewProcessor(strings.NewReader("1\nx\n2\ny\n3\n"), &out, func(b []byte) ([]byte, error) {
+			if _, err := strconv.Atoi(string(bytes.TrimSpace(b))); err != nil {
+				return nil, errors.New("not a number")
+			}
+			return b, nil
+		})
+		p.DeadLetterWriter = &dead
+		p.DeadLetterIncludeError = c.includeError
+		p.PassthroughOnError = c.passthrough
+		if err := p.Run(); err != nil {
+			t.Fatalf("[%s] p.Run: got %v, want nil", c.about, err)
+		}
+		if !LinesEqual(out.String(), c.out) {
+			t.Errorf("[%s] got %q, want %q", c.about, out.String(), c.out)
+		}
+		if !LinesEqual(dead.String(), c.dead) {
+			t.Errorf("[%s] got %q, want %q", c.about, dead.String(), c.dead)
+		}
+	}
+}
+
+// byteCounter counts the bytes written to the underlying writer.
+type byteCounter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestPostWriteFunc(t *testing.T) {
+	var (
+		buf     bytes.Buffer
+		counter *byteCounter
+	)
+	p := NewProcessor(strings.NewReader("a\nb\nc\n"), &buf, ToTransformerFunc(bytes.ToUpper))
+	p.PostWriteFunc = func(w io.Writer) io.Writer {
+		counter = &byteCounter{w: w}
+		return counter
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if !LinesEqual(buf.String(), "A\nB\nC\n") {
+		t.Errorf("p.Run: got %q, want %q", buf.String(), "A\nB\nC\n")
+	}
+	if counter.n != 6 {
+		t.Errorf("counter: got %d, want 6", counter.n)
+	}
+}
+
+func TestPreserveComments(t *testing.T) {
+	var cases = []struct {
+		about  string
+		prefix string
+		input  string
+		out    string
+	}{
+		{
+			about: `Comments and blank lines pass unchanged.`,
+			input: "# config\na=1\n\n# more\nb=2\n",
+			out:   "# config\nA=1\n\n# more\nB=2\n",
+		},
+		{
+			about:  `Custom prefix.`,
+			prefix: ";",
+			input:  "; config\na=1\n# b=2\n",
+			out:    "; config\nA=1\n# B=2\n",
+		},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		p := NewProcessor(strings.NewReader(c.input), &buf, ToTransformerFunc(bytes.ToUpper))
+		p.SingleWorkerOrdered()
+		p.PreserveComments = true
+		p.CommentPrefix = []byte(c.prefix)
+		if err := p.Run(); err != nil {
+			t.Fatalf("[%s] p.Run: got %v, want nil", c.about, err)
+		}
+		if buf.String() != c.out {
+			t.Errorf("[%s] got %q, want %q", c.about, buf.String(), c.out)
+		}
+	}
+}
+
+func TestRunN(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewProcessor(strings.NewReader("1\n2\n\n3\n4\n5\n"), &buf, func(b []byte) ([]byte, error) {
+		// Keep odd numbers only.
+		v, err := strconv.Atoi(string(bytes.TrimSpace(b)))
+		if err != nil || v%2 == 0 {
+			return nil, err
+		}
+		return b, nil
+	})
+	p.BatchSize = 2
+	n, err := p.RunN()
+	if err != nil {
+		t.Fatalf("p.RunN: got %v, want nil", err)
+	}
+	if want := int64(strings.Count(buf.String(), "\n")); n != want || n != 3 {
+		t.Errorf("p.RunN: got %d, want %d", n, want)
+	}
+}
+
+func TestAutoDetectLineEnding(t *testing.T) {
+	var cases = []struct {
+		about  string
+		input  string
+		detect bool
+		out    string
+	}{
+		{
+			about:  `Carriage returns are removed.`,
+			input:  "a\r\nb\r\nc\r\n",
+			detect: true,
+			out:    "a\nb\nc\n",
+		},
+		{
+			about:  `Last line without newline.`,
+			input:  "a\r\nb\r",
+			detect: true,
+			out:    "a\nb\n",
+		},
+		{
+			about:  `Mixed line endings, first line decides.`,
+			input:  "a\nb\r\n",
+			detect: true,
+			out:    "a\nb\r\n",
+		},
+		{
+			about:  `Detection disabled.`,
+			input:  "a\r\nb\r\n",
+			detect: false,
+			out:    "a\r\nb\r\n",
+		},
+	}
+	for _, c := range cases {
+		var (
+			buf  bytes.Buffer
+			seen atomic.Int64 // records containing a carriage return
+		)
+		p := NewProcessor(strings.NewReader(c.input), &buf, func(b []byte) ([]byte, error) {
+			if bytes.IndexByte(b, '\r') >= 0 {
+				seen.Add(1)
+			}
+			return b, nil
+		})
+		p.SingleWorkerOrdered()
+		p.AutoDetectLineEnding = c.detect
+		if err := p.Run(); err != nil {
+			t.Fatalf("[%s] p.Run: got %v, want nil", c.about, err)
+		}
+		if buf.String() != c.out {
+			t.Errorf("[%s] got %q, want %q", c.about, buf.String(), c.out)
+		}
+		if want := int64(strings.Count(c.out, "\r")); seen.Load() != want {
+			t.Errorf("[%s] got %d records with carriage return, want %d", c.about, seen.Load(), want)
+		}
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	var (
+		buf    syncBuffer
+		calls  atomic.Int64
+		errC   = make(chan error)
+		input  = strings.Repeat("a\n", 100)
+		expect = strings.Repeat("A\n", 100)
+	)
+	p := NewProcessor(strings.NewReader(input), &buf, func(b []byte) ([]byte, error) {
+		calls.Add(1)
+		return bytes.ToUpper(b), nil
+	})
+	p.BatchSize = 10
+	p.Pause()
+	go func() {
+		errC <- p.Run()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if n := calls.Load(); n != 0 {
+		t.Fatalf("paused: got %d calls, want 0", n)
+	}
+	p.Resume()
+	if err := <-errC; err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if buf.String() != expect {
+		t.Errorf("p.Run: got %q, want %q", buf.String(), expect)
+	}
+}
+
+func TestMaxErrors(t *testing.T) {
+	var (
+		buf   bytes.Buffer
+		calls int
+	)
+	p := NewProcessor(strings.NewReader("1\nx1\n2\nx2\nx3\n3\nx4\n4\nx5\n5\n"), &buf,
+		func(b []byte) ([]byte, error) {
+			calls++
+			if _, err := strconv.Atoi(string(bytes.TrimSpace(b))); err != nil {
+				return nil, fmt.Errorf("bad record: %s", bytes.TrimSpace(b))
+			}
+			return b, nil
+		})
+	p.SingleWorkerOrdered()
+	p.MaxErrors = 3
+	err := p.Run()
+	if !errors.Is(err, ErrTooManyErrors) {
+		t.Fatalf("p.Run: got %v, want %v", err, ErrTooManyErrors)
+	}
+	if n := strings.Count(err.Error(), "bad record"); n != 4 {
+		t.Errorf("p.Run: got %d errors, want 4", n)
+	}
+	if calls != 7 {
+		t.Errorf("got %d calls, want 7", calls)
+	}
+	if buf.String() != "1\n2\n3\n" {
+		t.Errorf("p.Run: got %q, want %q", buf.String(), "1\n2\n3\n")
+	}
+	// Errors below the threshold are tolerated.
+	buf.Reset()
+	p = NewProcessor(strings.NewReader("1\nx1\n2\n"), &buf, func(b []byte) ([]byte, error) {
+		if _, err := strconv.Atoi(string(bytes.TrimSpace(b))); err != nil {
+			return nil, err
+		}
+		return b, nil
+	})
+	p.MaxErrors = 3
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if !LinesEqual(buf.String(), "1\n2\n") {
+		t.Errorf("p.Run: got %q, want %q", buf.String(), "1\n2\n")
+	}
+}
+
+func TestAffinity(t *testing.T) {
+	var input, expected strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&input, "%d\n", i)
+		fmt.Fprintf(&expected, "%d\n", 2*i)
+	}
+	var buf bytes.Buffer
+	p := NewProcessor(strings.NewReader(input.String()), &buf, func(b []byte) ([]byte, error) {
+		v, err := strconv.Atoi(string(bytes.TrimSpace(b)))
+		if err != nil {
+			return nil, err
+		}
+		return []byte(fmt.Sprintf("%d\n", 2*v)), nil
+	})
+	p.NumWorkers = 4
+	p.BatchSize = 7
+	p.Affinity = true
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if !LinesEqual(buf.String(), expected.String()) {
+		t.Errorf("p.Run: output differs")
+	}
+}
+
+func BenchmarkAffinity(b *testing.B) {
+	var input bytes.Buffer
+	// Consecutive records share a key.
+	for i := 0; i < 200000; i++ {
+		fmt.Fprintf(&input, "%d\n", i/200)
+	}
+	// lookup touches a table entry per key, which is more likely to be
+	// cached, if contiguous records end up on the same core.
+	table := make([][]byte, 1000)
+	for i := range table {
+		table[i] = bytes.Repeat([]byte{byte(i)}, 4096)
+	}
+	lookup := func(b []byte) ([]byte, error) {
+		v, err := strconv.Atoi(string(bytes.TrimSpace(b)))
+		if err != nil {
+			return nil, err
+		}
+		var sum int
+		for _, c := range table[v%len(table)] {
+			sum += int(c)
+		}
+		return []byte(strconv.Itoa(sum)), nil
+	}
+	for _, affinity := range []bool{false, true} {
+		b.Run(fmt.Sprintf("affinity-%v", affinity), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				p := NewProcessor(bytes.NewReader(input.Bytes()), io.Discard, lookup)
+				p.BatchSize = 1000
+				p.Affinity = affinity
+				if err := p.Run(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestStatsBatchSizes(t *testing.T) {
+	var cases = []struct {
+		about     string
+		records   int
+		batchSize int
+		expected  Stats
+		mean      float64
+	}{
+		{
+			about:     `Last batch is partial.`,
+			records:   25,
+			batchSize: 10,
+			expected:  Stats{Batches: 3, Records: 25, MinBatchSize: 5, MaxBatchSize: 10, Written: 25},
+			mean:      25.0 / 3,
+		},
+		{
+			about:     `Exact multiple.`,
+			records:   20,
+			batchSize: 10,
+			expected:  Stats{Batches: 2, Records: 20, MinBatchSize: 10, MaxBatchSize: 10, Written: 20},
+			mean:      10,
+		},
+		{
+			about:     `Empty input.`,
+			records:   0,
+			batchSize: 10,
+			expected:  Stats{},
+			mean:      0,
+		},
+	}
+	for _, c := range cases {
+		input := strings.Repeat("a\n", c.records)
+		p := NewProcessor(strings.NewReader(input), io.Discard, ToTransformerFunc(bytes.ToUpper))
+		p.BatchSize = c.batchSize
+		if err := p.Run(); err != nil {
+			t.Fatalf("[%s] p.Run: got %v, want nil", c.about, err)
+		}
+		got := p.Stats()
+		if got.Elapsed <= 0 {
+			t.Errorf("[%s] got elapsed %v, want positive", c.about, got.Elapsed)
+		}
+		// Elapsed and wait times vary between runs.
+		got.Elapsed, got.QueueWait, got.OutputWait = 0, 0, 0
+		if got != c.expected {
+			t.Errorf("[%s] got %+v, want %+v", c.about, got, c.expected)
+		}
+		if got := p.Stats().MeanBatchSize(); got != c.mean {
+			t.Errorf("[%s] got %v, want %v", c.about, got, c.mean)
+		}
+	}
+}
+
+// slowWriter sleeps before each write.
+type slowWriter struct {
+	d time.Duration
+}
+
+func (w slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(w.d)
+	return len(p), nil
+}
+
+func TestStatsWait(t *testing.T) {
+	// Results larger than the output buffer are written one by one.
+	large := bytes.Repeat([]byte("x"), 8192)
+	var cases = []struct {
+		about        string
+		w            io.Writer
+		f            TransformerFunc
+		writerSlower bool
+	}{
+		{
+			about: `Slow writer.`,
+			w:     slowWriter{d: 2 * time.Millisecond},
+			f: func(b []byte) ([]byte, error) {
+				return large, nil
+			},
+			writerSlower: true,
+		},
+		{
+			about: `Slow workers.`,
+			w:     io.Discard,
+			f: func(b []byte) ([]byte, error) {
+				time.Sleep(2 * time.Millisecond)
+				return b, nil
+			},
+			writerSlower: false,
+		},
+	}
+	for _, c := range cases {
+		p := NewProcessor(strings.NewReader(strings.Repeat("a\n", 50)), c.w, c.f)
+		p.NumWorkers = 4
+		p.BatchSize = 1
+		if err := p.Run(); err != nil {
+			t.Fatalf("[%s] p.Run: got %v, want nil", c.about, err)
+		}
+		stats := p.Stats()
+		if got := stats.OutputWait > stats.QueueWait; got != c.writerSlower {
+			t.Errorf("[%s] got output wait %v, queue wait %v, want output wait dominating: %v",
+				c.about, stats.OutputWait, stats.QueueWait, c.writerSlower)
+		}
+	}
+}
+
+func TestSummaryFunc(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewProcessor(strings.NewReader("a\nb\n\nc\nd\n"), &buf, func(b []byte) ([]byte, error) {
+		if string(b) == "b\n" {
+			return nil, nil
+		}
+		return bytes.ToUpper(b), nil
+	})
+	p.BatchSize = 2
+	p.SummaryFunc = func(s Stats) []byte {
+		if s.Elapsed <= 0 {
+			return []byte("no timing\n")
+		}
+		return []byte(fmt.Sprintf("# %d records, %d batches, %d written\n", s.Records, s.Batches, s.Written))
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %q, want 4 lines", buf.String())
+	}
+	if want := "# 4 records, 2 batches, 3 written"; lines[3] != want {
+		t.Errorf("got %q, want %q", lines[3], want)
+	}
+	if !LinesEqual(strings.Join(lines[:3], "\n"), "A\nC\nD") {
+		t.Errorf("got %q, want %q", lines[:3], "A\nC\nD")
+	}
+}
+
+func TestFilterMap(t *testing.T) {
+	var cases = []struct {
+		about               string
+		nilMeansPassthrough bool
+	}{
+		{about: `Dropped records.`},
+		{about: `Dropped records with NilMeansPassthrough.`, nilMeansPassthrough: true},
+	}
+	for _, c := range cases {
+		var (
+			buf   bytes.Buffer
+			calls atomic.Int64
+		)
+		f := FilterMap(func(b []byte) bool {
+			return bytes.HasPrefix(b, []byte("a"))
+		}, func(b []byte) ([]byte, error) {
+			calls.Add(1)
+			return bytes.ToUpper(b), nil
+		})
+		p := NewProcessor(strings.NewReader("a\nb\nab\nc\n"), &buf, f)
+		p.NilMeansPassthrough = c.nilMeansPassthrough
+		if err := p.Run(); err != nil {
+			t.Fatalf("[%s] p.Run: got %v, want nil", c.about, err)
+		}
+		if !LinesEqual(buf.String(), "A\nAB\n") {
+			t.Errorf("[%s] p.Run: got %q, want %q", c.about, buf.String(), "A\nAB\n")
+		}
+		if n := calls.Load(); n != 2 {
+			t.Errorf("[%s] got %d calls, want 2", c.about, n)
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	var calls atomic.Int64
+	var (
+		trim = func(b []byte) ([]byte, error) {
+			return bytes.TrimSpace(b), nil
+		}
+		// dropB drops records starting with b.
+		dropB = func(b []byte) ([]byte, error) {
+			if bytes.HasPrefix(b, []byte("b")) {
+				return nil, nil
+			}
+			return b, nil
+		}
+		// upper fails on records starting with x.
+		upper = func(b []byte) ([]byte, error) {
+			calls.Add(1)
+			if bytes.HasPrefix(b, []byte("x")) {
+				return nil, errors.New("x not allowed")
+			}
+			return append(bytes.ToUpper(b), '\n'), nil
+		}
+	)
+	var cases = []struct {
+		about  string
+		input  string
+		result string
+		calls  int64
+		err    bool
+		// nilMeansPassthrough passes the original record through on the
+		// nil result of dropB.
+		nilMeansPassthrough bool
+	}{
+		{about: `Composed result.`, input: "  a  \n c\n", result: "A\nC\n", calls: 2},
+		{about: `Early drop.`, input: "a\n  b\nc\n", result: "A\nC\n", calls: 2},
+		{about: `Error.`, input: "x\n", calls: 1, err: true},
+		{about: `Inner nil passes the original record.`, input: "a\n  b\nc\n", result: "A\n  b\nC\n", calls: 2, nilMeansPassthrough: true},
+	}
+	for _, c := range cases {
+		calls.Store(0)
+		var buf bytes.Buffer
+		p := NewProcessor(strings.NewReader(c.input), &buf, Chain(trim, dropB, upper))
+		p.NumWorkers = 1
+		p.NilMeansPassthrough = c.nilMeansPassthrough
+		if err := p.Run(); (err != nil) != c.err {
+			t.Fatalf("[%s] p.Run: got %v, want error: %v", c.about, err, c.err)
+		}
+		if buf.String() != c.result {
+			t.Errorf("[%s] got %q, want %q", c.about, buf.String(), c.result)
+		}
+		if n := calls.Load(); n != c.calls {
+			t.Errorf("[%s] got %d calls, want %d", c.about, n, c.calls)
+		}
+	}
+	if b, err := Chain()([]byte("a")); string(b) != "a" || err != nil {
+		t.Errorf("empty chain: got %q, %v, want %q, nil", b, err, "a")
+	}
+}
+
+func TestSortBatchFunc(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewProcessor(strings.NewReader("d\nb\ne\na\nc\n"), &buf, ToTransformerFunc(bytes.ToUpper))
+	p.SortBatchFunc = func(a, b []byte) bool {
+		return bytes.Compare(a, b) < 0
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if buf.String() != "A\nB\nC\nD\nE\n" {
+		t.Errorf("p.Run: got %q, want %q", buf.String(), "A\nB\nC\nD\nE\n")
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewProcessor(strings.NewReader("a\n\nb\nc"), &buf, nil)
+	p.Identity = true
+	p.PreFilter = func(b []byte) bool { return !bytes.HasPrefix(b, []byte("b")) }
+	n, err := p.RunN()
+	if err != nil {
+		t.Fatalf("p.RunN: got %v, want nil", err)
+	}
+	if buf.String() != "a\nc\n" || n != 2 {
+		t.Errorf("p.RunN: got %q, %d, want %q, 2", buf.String(), n, "a\nc\n")
+	}
+	if s := p.Stats(); s.Written != 2 {
+		t.Errorf("p.Stats: got %d written, want 2", s.Written)
+	}
+	// Statistics are reset on each run.
+	p.R = strings.NewReader("d\n")
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if s := p.Stats(); s.Written != 1 {
+		t.Errorf("p.Stats: got %d written, want 1", s.Written)
+	}
+}
+
+func TestIdentityPauseResume(t *testing.T) {
+	var (
+		buf   syncBuffer
+		errC  = make(chan error)
+		input = strings.Repeat("a\n", 100)
+	)
+	p := NewProcessor(strings.NewReader(input), &buf, nil)
+	p.Identity = true
+	p.BatchSize = 10
+	p.Pause()
+	go func() {
+		errC <- p.Run()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	p.Flush()
+	if buf.String() != "" {
+		t.Fatalf("paused: got %q, want nothing", buf.String())
+	}
+	p.Resume()
+	if err := <-errC; err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if buf.String() != input {
+		t.Errorf("p.Run: got %q, want %q", buf.String(), input)
+	}
+}
+
+func BenchmarkIdentity(b *testing.B) {
+	var input bytes.Buffer
+	for i := 0; i < 200000; i++ {
+		fmt.Fprintf(&input, "record %d with some payload\n", i)
+	}
+	b.Run("transformer", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			p := NewProcessor(bytes.NewReader(input.Bytes()), io.Discard, func(b []byte) ([]byte, error) {
+				return b, nil
+			})
+			if err := p.Run(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("identity", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			p := NewProcessor(bytes.NewReader(input.Bytes()), io.Discard, nil)
+			p.Identity = true
+			if err := p.Run(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestWorkerErrorID(t *testing.T) {
+	errBoom := errors.New("boom")
+	p := NewProcessor(strings.NewReader("a\nb\nx\nc\n"), io.Discard, func(b []byte) ([]byte, error) {
+		if bytes.HasPrefix(b, []byte("x")) {
+			return nil, errBoom
+		}
+		return b, nil
+	})
+	p.NumWorkers = 4
+	p.BatchSize = 1
+	err := p.Run()
+	if !errors.Is(err, errBoom) {
+		t.Fatalf("p.Run: got %v, want %v", err, errBoom)
+	}
+	var id int
+	if _, serr := fmt.Sscanf(err.Error(), "worker %d: boom", &id); serr != nil {
+		t.Fatalf("got %q, want worker id", err)
+	}
+	if id < 0 || id >= p.NumWorkers {
+		t.Errorf("got worker %d, want id between 0 and %d", id, p.NumWorkers-1)
+	}
+}
+
+func TestAdaptiveBatchSize(t *testing.T) {
+	var cases = []struct {
+		about    string
+		adaptive bool
+		early    bool
+	}{
+		{about: `Fixed batch size waits for a full batch.`, adaptive: false, early: false},
+		{about: `Adaptive batch size starts small.`, adaptive: true, early: true},
+	}
+	for _, c := range cases {
+		var (
+			pr, pw = io.Pipe()
+			first  = make(chan struct{})
+			once   sync.Once
+		)
+		p := NewProcessor(pr, io.Discard, func(b []byte) ([]byte, error) {
+			once.Do(func() { close(first) })
+			return b, nil
+		})
+		p.BatchSize = 10000
+		p.AdaptiveBatchSize = c.adaptive
+		errC := make(chan error)
+		go func() {
+			errC <- p.Run()
+		}()
+		// Write a moderate number of records, but do not close the input yet.
+		fmt.Fprint(pw, strings.Repeat("a\n", 100))
+		var early bool
+		select {
+		case <-first:
+			early = true
+		case <-time.After(200 * time.Millisecond):
+		}
+		pw.Close()
+		if err := <-errC; err != nil {
+			t.Fatalf("[%s] p.Run: got %v, want nil", c.about, err)
+		}
+		if early != c.early {
+			t.Errorf("[%s] got early output %v, want %v", c.about, early, c.early)
+		}
+		if s := p.Stats(); s.Records != 100 {
+			t.Errorf("[%s] got %d records, want 100", c.about, s.Records)
+		}
+	}
+}
+
+// fakeLogger collects log messages.
+type fakeLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *fakeLogger) Printf(format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+}
+
+func TestLogger(t *testing.T) {
+	logger := &fakeLogger{}
+	p := NewProcessor(strings.NewReader(strings.Repeat("a\n", 10)), io.Discard, ToTransformerFunc(bytes.ToUpper))
+	p.BatchSize = 2
+	p.Verbose = true
+	p.Logger = logger
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if len(logger.messages) != 5 {
+		t.Fatalf("got %d messages, want 5", len(logger.messages))
+	}
+	if !strings.HasPrefix(logger.messages[0], "parallel: dispatched") {
+		t.Errorf("got %q, want progress message", logger.messages[0])
+	}
+}
+
+func TestRouteTransformer(t *testing.T) {
+	var (
+		input        = "a1\nb1\na2\nc1\nb2\n"
+		main, as, bs bytes.Buffer
+		created      []string
+	)
+	p := NewProcessor(strings.NewReader(input), &main, nil)
+	p.BatchSize = 2
+	p.RouteF = func(b []byte) ([]byte, string, error) {
+		return b, string(b[:1]), nil
+	}
+	p.Routes = map[string]io.Writer{"a": &as}
+	p.RouteWriter = func(route string) (io.Writer, error) {
+		created = append(created, route)
+		if route == "b" {
+			return &bs, nil
+		}
+		return nil, nil
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	var cases = []struct {
+		about string
+		buf   *bytes.Buffer
+		want  []string
+	}{
+		{about: `Static route.`, buf: &as, want: []string{"a1", "a2"}},
+		{about: `Created route.`, buf: &bs, want: []string{"b1", "b2"}},
+		{about: `Unknown route.`, buf: &main, want: []string{"c1"}},
+	}
+	for _, c := range cases {
+		got := strings.Fields(c.buf.String())
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("[%s] got %v, want %v", c.about, got, c.want)
+		}
+	}
+	sort.Strings(created)
+	if want := []string{"b", "c"}; !reflect.DeepEqual(created, want) {
+		t.Errorf("RouteWriter: got %v, want %v", created, want)
+	}
+	p = NewProcessor(strings.NewReader(input), &main, nil)
+	p.RouteF = func(b []byte) ([]byte, string, error) {
+		return b, "x", nil
+	}
+	errRoute := errors.New("no such route")
+	p.RouteWriter = func(route string) (io.Writer, error) {
+		return nil, errRoute
+	}
+	if err := p.Run(); !errors.Is(err, errRoute) {
+		t.Errorf("p.Run: got %v, want %v", err, errRoute)
+	}
+}
+
+// closeBuffer is a buffer, that records whether it has been closed.
+type closeBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *closeBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestRouteWriterClose(t *testing.T) {
+	var (
+		static  closeBuffer
+		created = make(map[string]*closeBuffer)
+	)
+	p := NewProcessor(strings.NewReader("a1\nb1\na2\nc1\n"), io.Discard, nil)
+	p.RouteF = func(b []byte) ([]byte, string, error) {
+		return b, string(b[:1]), nil
+	}
+	p.Routes = map[string]io.Writer{"a": &static}
+	p.RouteWriter = func(route string) (io.Writer, error) {
+		created[route] = &closeBuffer{}
+		return created[route], nil
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if static.closed {
+		t.Errorf("static route: got closed, want open")
+	}
+	for route, want := range map[string]string{"b": "b1\n", "c": "c1\n"} {
+		w, ok := created[route]
+		if !ok {
+			t.Fatalf("route %s: not created", route)
+		}
+		if !w.closed {
+			t.Errorf("route %s: got open, want closed", route)
+		}
+		if w.String() != want {
+			t.Errorf("route %s: got %q, want %q", route, w.String(), want)
+		}
+	}
+}
+
+func TestNilMeansPassthrough(t *testing.T) {
+	var cases = []struct {
+		about               string
+		nilMeansPassthrough bool
+		result              string
+	}{
+		{about: `Nil drops.`, nilMeansPassthrough: false, result: "B\n"},
+		{about: `Nil passes through.`, nilMeansPassthrough: true, result: "a\nB\n"},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		p := NewProcessor(strings.NewReader("a\nb\nc\n"), &buf, func(b []byte) ([]byte, error) {
+			switch string(b) {
+			case "a\n":
+				return nil, nil
+			case "b\n":
+				return bytes.ToUpper(b), nil
+			default:
+				return []byte{}, nil
+			}
+		})
+		p.NumWorkers = 1
+		p.NilMeansPassthrough = c.nilMeansPassthrough
+		if err := p.Run(); err != nil {
+			t.Fatalf("[%s] p.Run: got %v, want nil", c.about, err)
+		}
+		if buf.String() != c.result {
+			t.Errorf("[%s] got %q, want %q", c.about, buf.String(), c.result)
+		}
+	}
+}
+
+func TestDroppedWriter(t *testing.T) {
+	var cases = []struct {
+		about               string
+		nilMeansPassthrough bool
+		result              string
+		dropped             string
+	}{
+		{about: `Nil and empty results are dropped.`, result: "B\n", dropped: "a\nc\nd\n"},
+		{about: `Nil passes through.`, nilMeansPassthrough: true, result: "a\nB\n", dropped: "c\nd\n"},
+	}
+	for _, c := range cases {
+		var buf, dropped bytes.Buffer
+		p := NewProcessor(strings.NewReader("a\nb\nc\n\nd\ne\n"), &buf, func(b []byte) ([]byte, error) {
+			switch string(b) {
+			case "a\n":
+				return nil, nil
+			case "b\n":
+				return bytes.ToUpper(b), nil
+			case "e\n":
+				return nil, errors.New("failed")
+			default:
+				return []byte{}, nil
+			}
+		})
+		p.NumWorkers = 1
+		p.SkipEmptyLines = true
+		p.DeadLetterWriter = io.Discard
+		p.DroppedWriter = &dropped
+		p.NilMeansPassthrough = c.nilMeansPassthrough
+		if err := p.Run(); err != nil {
+			t.Fatalf("[%s] p.Run: got %v, want nil", c.about, err)
+		}
+		if buf.String() != c.result {
+			t.Errorf("[%s] got %q, want %q", c.about, buf.String(), c.result)
+		}
+		if dropped.String() != c.dropped {
+			t.Errorf("[%s] dropped: got %q, want %q", c.about, dropped.String(), c.dropped)
+		}
+	}
+}
+
+func TestOutputOffset(t *testing.T) {
+	var input bytes.Buffer
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&input, "%d\n", i)
+	}
+	var cases = []struct {
+		about         string
+		skipProcessed bool
+		identity      bool
+	}{
+		{about: `Records are processed again.`},
+		{about: `Records are skipped.`, skipProcessed: true},
+		{about: `Identity.`, identity: true},
+	}
+	for _, c := range cases {
+		var (
+			first, second bytes.Buffer
+			calls         int
+		)
+		f := func(b []byte) ([]byte, error) {
+			calls++
+			return b, nil
+		}
+		// The first run is interrupted by an output limit.
+		p := NewProcessor(bytes.NewReader(input.Bytes()), &first, f)
+		p.NumWorkers = 1
+		p.BatchSize = 7
+		p.MaxOutputBytes = 50
+		p.Identity = c.identity
+		if err := p.Run(); err != nil {
+			t.Fatalf("[%s] p.Run: got %v, want nil", c.about, err)
+		}
+		offset := int64(bytes.Count(first.Bytes(), []byte("\n")))
+		calls = 0
+		p = NewProcessor(bytes.NewReader(input.Bytes()), &second, f)
+		p.NumWorkers = 1
+		p.BatchSize = 7
+		p.Identity = c.identity
+		p.OutputOffset = offset
+		p.SkipProcessed = c.skipProcessed
+		if err := p.Run(); err != nil {
+			t.Fatalf("[%s] p.Run: got %v, want nil", c.about, err)
+		}
+		if got := first.String() + second.String(); got != input.String() {
+			t.Errorf("[%s] got %q, want %q", c.about, got, input.String())
+		}
+		want := 100
+		switch {
+		case c.identity:
+			want = 0
+		case c.skipProcessed:
+			want = 100 - int(offset)
+		}
+		if calls != want {
+			t.Errorf("[%s] got %d calls, want %d", c.about, calls, want)
+		}
+	}
+	p := NewProcessor(bytes.NewReader(input.Bytes()), &bytes.Buffer{}, ToTransformerFunc(bytes.ToUpper))
+	p.NumWorkers = 2
+	p.OutputOffset = 1
+	if err := p.Run(); err != ErrOutputOffsetWorkers {
+		t.Errorf("p.Run: got %v, want %v", err, ErrOutputOffsetWorkers)
+	}
+}
+
+func TestKeyFunc(t *testing.T) {
+	var (
+		input    strings.Builder
+		expected = make(map[string][]string)
+	)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("k%d", i%13)
+		fmt.Fprintf(&input, "%s %d\n", key, i)
+		expected[key] = append(expected[key], strconv.Itoa(i))
+	}
+	var buf bytes.Buffer
+	p := NewProcessor(strings.NewReader(input.String()), &buf, func(b []byte) ([]byte, error) {
+		// Uneven delays would reorder records without KeyFunc.
+		if len(b)%3 == 0 {
+			time.Sleep(50 * time.Microsecond)
+		}
+		return b, nil
+	})
+	p.BatchSize = 3
+	p.NumWorkers = 4
+	p.KeyFunc = func(b []byte) string {
+		return string(b[:bytes.IndexByte(b, ' ')])
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	result := make(map[string][]string)
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		fields := strings.Fields(line)
+		result[fields[0]] = append(result[fields[0]], fields[1])
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("got %v, want %v", result, expected)
+	}
+	// Keys are spread across workers, so they are processed independently.
+	parts := make(map[int]bool)
+	for key := range expected {
+		parts[partition(key, p.NumWorkers)] = true
+	}
+	if len(parts) < 2 {
+		t.Errorf("got keys on %d workers, want at least 2", len(parts))
+	}
+}
+
+// dataErrReader returns all its data together with an error.
+type dataErrReader struct {
+	data string
+	err  error
+}
+
+func (r *dataErrReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	if len(r.data) == 0 {
+		return n, r.err
+	}
+	return n, nil
+}
+
+func TestReadErrorWithData(t *testing.T) {
+	errRead := errors.New("connection reset")
+	var cases = []struct {
+		about     string
+		identity  bool
+		splitFunc bufio.SplitFunc
+		result    string
+	}{
+		{about: `Records.`, result: "A\nB\nC\n"},
+		{about: `Identity.`, identity: true, result: "a\nb\nc\n"},
+		{about: `Split function.`, splitFunc: bufio.ScanLines, result: "ABC"},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		p := NewProcessor(&dataErrReader{data: "a\nb\nc", err: errRead}, &buf, ToTransformerFunc(bytes.ToUpper))
+		p.NumWorkers = 1
+		p.Identity = c.identity
+		p.SplitFunc = c.splitFunc
+		if err := p.Run(); err != errRead {
+			t.Fatalf("[%s] p.Run: got %v, want %v", c.about, err, errRead)
+		}
+		if buf.String() != c.result {
+			t.Errorf("[%s] got %q, want %q", c.about, buf.String(), c.result)
+		}
+	}
+}
+
+func TestSkipFunc(t *testing.T) {
+	// commentOnly skips blank lines and lines containing only a comment.
+	commentOnly := func(b []byte) bool {
+		b = bytes.TrimSpace(b)
+		return len(b) == 0 || bytes.HasPrefix(b, []byte("#"))
+	}
+	var cases = []struct {
+		about    string
+		skipFunc func([]byte) bool
+		result   string
+	}{
+		{about: `Default skips blank lines only.`, result: "A\n  # C\nB # D\n"},
+		{about: `Comment-only lines.`, skipFunc: commentOnly, result: "A\nB # D\n"},
+		{
+			about:    `Nothing skipped.`,
+			skipFunc: func([]byte) bool { return false },
+			result:   "A\n\n  # C\n \nB # D\n",
+		},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		p := NewProcessor(strings.NewReader("a\n\n  # c\n \nb # d\n"), &buf, ToTransformerFunc(bytes.ToUpper))
+		p.NumWorkers = 1
+		p.SkipFunc = c.skipFunc
+		if err := p.Run(); err != nil {
+			t.Fatalf("[%s] p.Run: got %v, want nil", c.about, err)
+		}
+		if buf.String() != c.result {
+			t.Errorf("[%s] got %q, want %q", c.about, buf.String(), c.result)
+		}
+	}
+}
+
+func TestStopOnSentinel(t *testing.T) {
+	// semicolon splits on semicolons, which are not part of the token.
+	semicolon := func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, ';'); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+	var cases = []struct {
+		about    string
+		input    string
+		identity bool
+		split    bufio.SplitFunc
+		result   string
+	}{
+		{about: `Sentinel in the middle.`, input: "a\nb\n__END__\nc\nd\n", result: "A\nB\n"},
+		{about: `Sentinel first.`, input: "__END__\na\n", result: ""},
+		{about: `Sentinel as prefix only.`, input: "a\n__END__x\n__END__\nb\n", result: "A\n__END__X\n"},
+		{about: `Identity.`, input: "a\n__END__\nb\n", identity: true, result: "a\n"},
+		{about: `SplitFunc tokens compared as they are.`, input: "a;__END__\n;__END__;b;", split: semicolon, result: "A__END__\n"},
+	}
+	for _, c := range cases {
+		// The input is never closed, like an interactive standard input.
+		pr, pw := io.Pipe()
+		go func() {
+			_, _ = io.WriteString(pw, c.input)
+		}()
+		var buf bytes.Buffer
+		p := NewProcessor(pr, &buf, ToTransformerFunc(bytes.ToUpper))
+		p.NumWorkers = 1
+		p.Identity = c.identity
+		p.SplitFunc = c.split
+		p.StopOnSentinel = []byte("__END__")
+		if err := p.Run(); err != nil {
+			t.Fatalf("[%s] p.Run: got %v, want nil", c.about, err)
+		}
+		if buf.String() != c.result {
+			t.Errorf("[%s] got %q, want %q", c.about, buf.String(), c.result)
+		}
+		pr.Close()
+	}
+}
+
+func TestAutoDetectLineEndingStream(t *testing.T) {
+	// The input is never closed, so detection must not wait for more than
+	// the first line.
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		_, _ = io.WriteString(pw, "a\r\nb\r\n__END__\r\n")
+	}()
+	var buf bytes.Buffer
+	p := NewProcessor(pr, &buf, ToTransformerFunc(bytes.ToUpper))
+	p.NumWorkers = 1
+	p.AutoDetectLineEnding = true
+	p.StopOnSentinel = []byte("__END__")
+	errC := make(chan error)
+	go func() {
+		errC <- p.Run()
+	}()
+	select {
+	case err := <-errC:
+		if err != nil {
+			t.Fatalf("p.Run: got %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("p.Run: blocked on detecting the line ending")
+	}
+	if buf.String() != "A\nB\n" {
+		t.Errorf("got %q, want %q", buf.String(), "A\nB\n")
+	}
+}
+
+func TestTransformerValidation(t *testing.T) {
+	f := ToTransformerFunc(bytes.ToUpper)
+	offsetF := func(offset int64, b []byte) ([]byte, error) {
+		return b, nil
+	}
+	var cases = []struct {
+		about    string
+		f        TransformerFunc
+		offsetF  OffsetTransformerFunc
+		identity bool
+		err      error
+	}{
+		{about: `F only.`, f: f, err: nil},
+		{about: `OffsetF only.`, offsetF: offsetF, err: nil},
+		{about: `F and OffsetF.`, f: f, offsetF: offsetF, err: ErrTransformer},
+		{about: `No transformer.`, err: ErrTransformer},
+		{about: `No transformer with Identity.`, identity: true, err: nil},
+	}
+	for _, c := range cases {
+		p := NewProcessor(strings.NewReader("a\n"), io.Discard, c.f)
+		p.OffsetF = c.offsetF
+		p.Identity = c.identity
+		if err := p.Run(); err != c.err {
+			t.Errorf("[%s] p.Run: got %v, want %v", c.about, err, c.err)
+		}
+	}
+}
+
+// writeCounter counts the number of Write calls.
+type writeCounter struct {
+	w io.Writer
+	n int
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.n++
+	return w.w.Write(p)
+}
+
+func TestWriteBufferSize(t *testing.T) {
+	input := strings.Repeat("a\n", 10000)
+	var cases = []struct {
+		about  string
+		size   int
+		writes int
+	}{
+		{about: `Default size.`, size: 0, writes: 5},
+		{about: `Small buffer.`, size: 1000, writes: 20},
+		{about: `Buffer larger than the output.`, size: 65536, writes: 1},
+	}
+	for _, c := range cases {
+		var (
+			buf bytes.Buffer
+			w   = &writeCounter{w: &buf}
+		)
+		p := NewProcessor(strings.NewReader(input), w, ToTransformerFunc(bytes.ToUpper))
+		p.WriteBufferSize = c.size
+		n, err := p.RunN()
+		if err != nil {
+			t.Fatalf("[%s] p.RunN: got %v, want nil", c.about, err)
+		}
+		if n != 10000 {
+			t.Errorf("[%s] p.RunN: got %d, want 10000", c.about, n)
+		}
+		if want := strings.ToUpper(input); buf.String() != want {
+			t.Errorf("[%s] got %d bytes, want %d", c.about, buf.Len(), len(want))
+		}
+		if w.n != c.writes {
+			t.Errorf("[%s] got %d writes, want %d", c.about, w.n, c.writes)
+		}
+	}
+}
+
+func BenchmarkWriteBufferSize(b *testing.B) {
+	data := bytes.Repeat([]byte("x\n"), 500000)
+	for _, size := range []int{0, 65536, 1048576} {
+		b.Run(fmt.Sprintf("size-%d", size), func(b *testing.B) {
+			var writes int
+			for i := 0; i < b.N; i++ {
+				w := &writeCounter{w: io.Discard}
+				p := NewProcessor(bytes.NewReader(data), w, func(b []byte) ([]byte, error) {
+					return b, nil
+				})
+				p.WriteBufferSize = size
+				if err := p.Run(); err != nil {
+					b.Fatal(err)
+				}
+				writes += w.n
+			}
+			b.ReportMetric(float64(writes)/float64(b.N), "writes/op")
+		})
+	}
+}
+
+func TestPreFilter(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewProcessor(strings.NewReader("a\nb\nab\nc\n"), &buf, ToTransformerFunc(bytes.ToUpper))
+	p.PreFilter = func(b []byte) bool {
+		return bytes.HasPrefix(b, []byte("a"))
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if !LinesEqual(buf.String(), "A\nAB\n") {
+		t.Errorf("p.Run: got %q, want %q", buf.String(), "A\nAB\n")
+	}
+}
+
+func BenchmarkPreFilter(b *testing.B) {
+	var input bytes.Buffer
+	for i := 0; i < 200000; i++ {
+		fmt.Fprintf(&input, "record %d with some payload\n", i)
+	}
+	// selective keeps about one in a thousand records.
+	selective := func(b []byte) bool {
+		return bytes.HasSuffix(bytes.Fields(b)[1], []byte("000"))
+	}
+	b.Run("in-transformer", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			p := NewProcessor(bytes.NewReader(input.Bytes()), io.Discard, func(b []byte) ([]byte, error) {
+				if !selective(b) {
+					return nil, nil
+				}
+				return bytes.ToUpper(b), nil
+			})
+			if err := p.Run(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("pre-filter", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			p := NewProcessor(bytes.NewReader(input.Bytes()), io.Discard, ToTransformerFunc(bytes.ToUpper))
+			p.PreFilter = selective
+			if err := p.Run(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// upperASCII converts ASCII letters to upper case in place.
+func upperASCII(b []byte) {
+	for i, c := range b {
+		if 'a' <= c && c <= 'z' {
+			b[i] = c - 'a' + 'A'
+		}
+	}
+}
+
+func TestInPlaceTransformer(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewProcessor(strings.NewReader("a\nb\nc"), &buf, nil)
+	p.InPlaceF = upperASCII
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if !LinesEqual(buf.String(), "A\nB\nC\n") {
+		t.Errorf("p.Run: got %q, want %q", buf.String(), "A\nB\nC\n")
+	}
+}
+
+func BenchmarkInPlace(b *testing.B) {
+	var input bytes.Buffer
+	for i := 0; i < 200000; i++ {
+		fmt.Fprintf(&input, "record %d with some payload\n", i)
+	}
+	// Both variants do the same ASCII upper-casing, the allocating one on a
+	// copy of the record.
+	b.Run("allocating", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			p := NewProcessor(bytes.NewReader(input.Bytes()), io.Discard, func(b []byte) ([]byte, error) {
+				b = bytes.Clone(b)
+				upperASCII(b)
+				return b, nil
+			})
+			if err := p.Run(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("in-place", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			p := NewProcessor(bytes.NewReader(input.Bytes()), io.Discard, nil)
+			p.InPlaceF = upperASCII
+			if err := p.Run(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestFlush(t *testing.T) {
+	var cases = []struct {
+		about    string
+		identity bool
+		expected string
+	}{
+		{about: `Transformer.`, expected: "A\nB\nC\n"},
+		{about: `Identity.`, identity: true, expected: "a\nb\nc\n"},
+	}
+	for _, c := range cases {
+		var (
+			pr, pw = io.Pipe()
+			buf    syncBuffer
+			errC   = make(chan error)
+		)
+		p := NewProcessor(pr, &buf, ToTransformerFunc(bytes.ToUpper))
+		p.BatchSize = 1
+		p.Identity = c.identity
+		p.Flush() // not running, no-op
+		go func() {
+			errC <- p.Run()
+		}()
+		if _, err := io.WriteString(pw, "a\nb\nc\n"); err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for !LinesEqual(buf.String(), c.expected) {
+			if time.Now().After(deadline) {
+				t.Fatalf("[%s] got %q before Run returned, want all records", c.about, buf.String())
+			}
+			p.Flush()
+			time.Sleep(time.Millisecond)
+		}
+		pw.Close()
+		if err := <-errC; err != nil {
+			t.Fatalf("[%s] p.Run: got %v, want nil", c.about, err)
+		}
+	}
+}
+
+func TestOffsetTransformer(t *testing.T) {
+	input := "ab\n\ncde\nf\nghij\nk"
+	var (
+		mu      sync.Mutex
+		offsets = make(map[string]int64)
+	)
+	p := NewProcessor(strings.NewReader(input), io.Discard, nil)
+	p.BatchSize = 2
+	p.StripSeparator = true
+	p.OffsetF = func(offset int64, b []byte) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		offsets[string(b)] = offset
+		return nil, nil
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	// The empty line at offset 3 is skipped, but still counted.
+	expected := map[string]int64{"ab": 0, "cde": 4, "f": 8, "ghij": 10, "k": 15}
+	if !reflect.DeepEqual(offsets, expected) {
+		t.Fatalf("got %v, want %v", offsets, expected)
+	}
+	for s, offset := range offsets {
+		if input[offset:offset+int64(len(s))] != s {
+			t.Errorf("offset %d does not point to %q", offset, s)
+		}
+	}
+}
+
+func TestNumWorkersWorkload(t *testing.T) {
+	n := runtime.GOMAXPROCS(0)
+	var cases = []struct {
+		workload   Workload
+		numWorkers int
+		expected   int
+	}{
+		{IOBound, 4 * n, 4 * n},
+		{CPUBound, 4 * n, n},
+		{CPUBound, 1, 1},
+	}
+	for _, c := range cases {
+		p := NewProcessor(nil, nil, nil)
+		p.Workload = c.workload
+		p.NumWorkers = c.numWorkers
+		if got := p.numWorkers(); got != c.expected {
+			t.Errorf("got %d, want %d", got, c.expected)
+		}
+	}
+}
+
+// BenchmarkWorkload runs a CPU-bound transformer with different numbers of
+// workers. Compare the unclamped IOBound runs with many workers to the
+// CPUBound runs, which never exceed GOMAXPROCS workers.
+func BenchmarkWorkload(b *testing.B) {
+	var input bytes.Buffer
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&input, "record %d\n", i)
+	}
+	// spin burns CPU, roughly proportional to the record size.
+	spin := func(b []byte) ([]byte, error) {
+		var h uint32
+		for i := 0; i < 2000; i++ {
+			for _, c := range b {
+				h = h*31 + uint32(c)
+			}
+		}
+		return []byte(strconv.Itoa(int(h))), nil
+	}
+	n := runtime.GOMAXPROCS(0)
+	names := map[Workload]string{IOBound: "io", CPUBound: "cpu"}
+	for _, workload := range []Workload{IOBound, CPUBound} {
+		for _, numWorkers := range []int{n, 4 * n, 64 * n} {
+			b.Run(fmt.Sprintf("%s-workers-%d", names[workload], numWorkers), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					p := NewProcessor(bytes.NewReader(input.Bytes()), io.Discard, spin)
+					p.BatchSize = 100
+					p.Workload = workload
+					p.NumWorkers = numWorkers
+					if err := p.Run(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestSplitFunc(t *testing.T) {
+	var cases = []struct {
+		about    string
+		input    string
+		split    bufio.SplitFunc
+		expected string
+	}{
+		{
+			about:    `Split on words.`,
+			input:    "a b  c\nd",
+			split:    bufio.ScanWords,
+			expected: "A\nB\nC\nD\n",
+		},
+		{
+			about:    `Split on XML elements.`,
+			input:    "<x><a>1</a> <a>2</a>\n<a>3</a></x>",
+			split:    (&record.TagSplitter{Tag: "a", MaxBytesApprox: 1}).Split,
+			expected: "<A>1</A>\n<A>2</A>\n<A>3</A>\n",
+		},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		p := NewProcessor(strings.NewReader(c.input), &buf, func(b []byte) ([]byte, error) {
+			return append(bytes.ToUpper(b), '\n'), nil
+		})
+		p.SplitFunc = c.split
+		p.BatchSize = 2
+		if err := p.Run(); err != nil {
+			t.Fatalf("[%s] p.Run: got %v, want nil", c.about, err)
+		}
+		if !LinesEqual(buf.String(), c.expected) {
+			t.Errorf("[%s] p.Run: got %q, want %q", c.about, buf.String(), c.expected)
+		}
+	}
+}
+
+func TestSplitFuncMaxTokenSize(t *testing.T) {
+	var cases = []struct {
+		about        string
+		tokenSize    int
+		maxTokenSize int
+		err          error
+	}{
+		{about: `Default limit.`, tokenSize: 100, err: nil},
+		{about: `Token exceeds default limit.`, tokenSize: bufio.MaxScanTokenSize + 1, err: bufio.ErrTooLong},
+		{about: `Raised limit.`, tokenSize: bufio.MaxScanTokenSize + 1, maxTokenSize: 1 << 20, err: nil},
+		{about: `Lowered limit.`, tokenSize: 100, maxTokenSize: 16, err: bufio.ErrTooLong},
+	}
+	for _, c := range cases {
+		var (
+			input = "a " + strings.Repeat("b", c.tokenSize) + " c"
+			buf   bytes.Buffer
+		)
+		p := NewProcessor(strings.NewReader(input), &buf, func(b []byte) ([]byte, error) {
+			return append(b, '\n'), nil
+		})
+		p.SplitFunc = bufio.ScanWords
+		p.MaxTokenSize = c.maxTokenSize
+		if err := p.Run(); !errors.Is(err, c.err) {
+			t.Fatalf("[%s] p.Run: got %v, want %v", c.about, err, c.err)
+		}
+		if c.err == nil && buf.Len() != len(input)+1 {
+			t.Errorf("[%s] got %d bytes, want %d", c.about, buf.Len(), len(input)+1)
+		}
+	}
+}
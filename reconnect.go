@@ -0,0 +1,98 @@
+package parallel
+
+import (
+	"errors"
+	"io"
+	"syscall"
+	"time"
+)
+
+// ReconnectingReader reads from a connection and reconnects, if a read fails
+// with a recoverable error, e.g. when a TCP connection drops. Reading then
+// continues with whatever the new connection delivers, so the source must
+// resume the stream itself; a record cut by a disconnect may be incomplete.
+// A clean io.EOF ends the stream.
+type ReconnectingReader struct {
+	// Dial opens a new connection. If the returned reader is an io.Closer,
+	// it is closed before reconnecting.
+	Dial func() (io.Reader, error)
+	// MaxRetries is the number of consecutive failed attempts, after which
+	// the last error is returned.
+	MaxRetries int
+	// Backoff is the initial wait before reconnecting, doubled with each
+	// failed attempt up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// IsRecoverable reports, whether to reconnect after a read error.
+	// Defaults to unexpected EOF and connection reset or aborted errors.
+	IsRecoverable func(error) bool
+
+	r io.Reader
+}
+
+// NewReconnectingReader creates a new reader using dial to connect.
+func NewReconnectingReader(dial func() (io.Reader, error)) *ReconnectingReader {
+	return &ReconnectingReader{
+		Dial:       dial,
+		MaxRetries: 5,
+		Backoff:    100 * time.Millisecond,
+		MaxBackoff: 10 * time.Second,
+	}
+}
+
+// isRecoverable reports, whether err is worth reconnecting.
+func (r *ReconnectingReader) isRecoverable(err error) bool {
+	if r.IsRecoverable != nil {
+		return r.IsRecoverable(err)
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// connect closes the current connection, if any, and dials a new one, waiting
+// between failed attempts. The initial connection is also retried, if the
+// error is recoverable.
+func (r *ReconnectingReader) connect() error {
+	if c, ok := r.r.(io.Closer); ok {
+		_ = c.Close()
+	}
+	r.r = nil
+	var (
+		wait = r.Backoff
+		err  error
+	)
+	for i := 0; i <= r.MaxRetries; i++ {
+		if i > 0 {
+			time.Sleep(wait)
+			if wait *= 2; r.MaxBackoff > 0 && wait > r.MaxBackoff {
+				wait = r.MaxBackoff
+			}
+		}
+		if r.r, err = r.Dial(); err == nil {
+			return nil
+		}
+		if !r.isRecoverable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// Read reads from the current connection, reconnecting as needed.
+func (r *ReconnectingReader) Read(p []byte) (int, error) {
+	if r.r == nil {
+		if err := r.connect(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.r.Read(p)
+	if err == nil || err == io.EOF || !r.isRecoverable(err) {
+		return n, err
+	}
+	if cerr := r.connect(); cerr != nil {
+		return n, cerr
+	}
+	return n, nil
+}
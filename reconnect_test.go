@@ -0,0 +1,55 @@
+package parallel
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReconnectingReader(t *testing.T) {
+	var (
+		conns = []io.Reader{
+			io.MultiReader(strings.NewReader("a\nb\n"), iotest.ErrReader(io.ErrUnexpectedEOF)),
+			iotest.ErrReader(io.ErrUnexpectedEOF),
+			strings.NewReader("c\nd\n"),
+		}
+		dials int
+	)
+	r := NewReconnectingReader(func() (io.Reader, error) {
+		if dials == 1 {
+			dials++
+			return nil, io.ErrUnexpectedEOF // fails once
+		}
+		c := conns[0]
+		conns = conns[1:]
+		dials++
+		return c, nil
+	})
+	r.Backoff = 0
+	var buf bytes.Buffer
+	p := NewProcessor(r, &buf, ToTransformerFunc(bytes.ToUpper))
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if !LinesEqual(buf.String(), "A\nB\nC\nD\n") {
+		t.Errorf("p.Run: got %q, want %q", buf.String(), "A\nB\nC\nD\n")
+	}
+	// Unrecoverable errors and exhausted retries are returned.
+	errBroken := errors.New("broken")
+	r = NewReconnectingReader(func() (io.Reader, error) {
+		return iotest.ErrReader(errBroken), nil
+	})
+	if _, err := io.ReadAll(r); err != errBroken {
+		t.Errorf("io.ReadAll: got %v, want %v", err, errBroken)
+	}
+	r = NewReconnectingReader(func() (io.Reader, error) {
+		return nil, io.ErrUnexpectedEOF
+	})
+	r.Backoff, r.MaxRetries = 0, 2
+	if _, err := io.ReadAll(r); err != io.ErrUnexpectedEOF {
+		t.Errorf("io.ReadAll: got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
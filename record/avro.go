@@ -0,0 +1,220 @@
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	ErrInvalidAvroOCF    = errors.New("invalid avro object container file")
+	ErrAvroSyncMismatch  = errors.New("avro sync marker mismatch")
+	ErrTruncatedAvroData = errors.New("truncated avro data")
+)
+
+// avroMagic starts every avro object container file.
+var avroMagic = []byte("Obj\x01")
+
+// avroSyncSize is the size of the sync marker.
+const avroSyncSize = 16
+
+// avroDecoder reads avro primitives from a buffer. Methods report false, if
+// the buffer ends early.
+type avroDecoder struct {
+	b   []byte
+	off int
+}
+
+// long reads a zig-zag encoded variable length integer.
+func (d *avroDecoder) long() (int64, bool, error) {
+	v, n := binary.Varint(d.b[d.off:])
+	switch {
+	case n == 0:
+		return 0, false, nil
+	case n < 0:
+		return 0, false, ErrInvalidAvroOCF
+	}
+	d.off += n
+	return v, true, nil
+}
+
+// skip skips n bytes.
+func (d *avroDecoder) skip(n int64) (bool, error) {
+	if n < 0 {
+		return false, ErrInvalidAvroOCF
+	}
+	if n > int64(len(d.b)-d.off) {
+		return false, nil
+	}
+	d.off += int(n)
+	return true, nil
+}
+
+// skipBytes skips a length prefixed string or byte sequence.
+func (d *avroDecoder) skipBytes() (bool, error) {
+	n, ok, err := d.long()
+	if !ok || err != nil {
+		return false, err
+	}
+	return d.skip(n)
+}
+
+// header reads the file header and returns the sync marker.
+func (d *avroDecoder) header() ([]byte, bool, error) {
+	if len(d.b) < len(avroMagic) {
+		if !bytes.HasPrefix(avroMagic, d.b) {
+			return nil, false, ErrInvalidAvroOCF
+		}
+		return nil, false, nil
+	}
+	if !bytes.HasPrefix(d.b, avroMagic) {
+		return nil, false, ErrInvalidAvroOCF
+	}
+	d.off = len(avroMagic)
+	// The metadata is a map, encoded as a series of blocks, terminated by an
+	// empty block. A negative count is followed by the size of the block.
+	for {
+		count, ok, err := d.long()
+		if !ok || err != nil {
+			return nil, false, err
+		}
+		if count == 0 {
+			break
+		}
+		if count < 0 {
+			count = -count
+			if _, ok, err := d.long(); !ok || err != nil {
+				return nil, false, err
+			}
+		}
+		for i := int64(0); i < count; i++ {
+			for j := 0; j < 2; j++ {
+				if ok, err := d.skipBytes(); !ok || err != nil {
+					return nil, false, err
+				}
+			}
+		}
+	}
+	start := d.off
+	if ok, err := d.skip(avroSyncSize); !ok || err != nil {
+		return nil, false, err
+	}
+	return d.b[start:d.off], true, nil
+}
+
+// block reads a data block, followed by the sync marker, and returns the
+// block without the marker.
+func (d *avroDecoder) block(sync []byte) ([]byte, bool, error) {
+	start := d.off
+	count, ok, err := d.long()
+	if !ok || err != nil {
+		return nil, false, err
+	}
+	size, ok, err := d.long()
+	if !ok || err != nil {
+		return nil, false, err
+	}
+	if count < 0 {
+		return nil, false, ErrInvalidAvroOCF
+	}
+	if ok, err := d.skip(size); !ok || err != nil {
+		return nil, false, err
+	}
+	end := d.off
+	if ok, err := d.skip(avroSyncSize); !ok || err != nil {
+		return nil, false, err
+	}
+	if !bytes.Equal(d.b[end:d.off], sync) {
+		return nil, false, ErrAvroSyncMismatch
+	}
+	return d.b[start:end], true, nil
+}
+
+// NewAvroOCFSplitter returns a split function for avro object container
+// files. The header is parsed for the sync marker, then each data block is
+// returned as a token, so blocks can be decoded in parallel. A token is the
+// block as stored in the file, the number of objects and the size of the
+// serialized objects, followed by the objects, possibly compressed, without
+// the sync marker; use DecodeAvroBlock to separate them. The header, and with
+// it the schema and codec, is not returned. Each block must be followed by
+// the sync marker of the header, otherwise ErrAvroSyncMismatch is returned.
+func NewAvroOCFSplitter() bufio.SplitFunc {
+	// blocks splits the data blocks, once the header has been consumed.
+	var blocks bufio.SplitFunc
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if blocks != nil {
+			return blocks(data, atEOF)
+		}
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		d := &avroDecoder{b: data}
+		marker, ok, err := d.header()
+		if err != nil {
+			return 0, nil, err
+		}
+		if !ok {
+			if atEOF {
+				return 0, nil, ErrTruncatedAvroData
+			}
+			return 0, nil, nil
+		}
+		if d.off == len(data) && atEOF {
+			// A file without any blocks.
+			return len(data), nil, nil
+		}
+		split := avroBlockSplitter(bytes.Clone(marker))
+		advance, token, err = splitAfter(split, d.off, data, atEOF)
+		if advance > 0 {
+			blocks = split
+		}
+		return advance, token, err
+	}
+}
+
+// avroBlockSplitter returns a split function for data blocks, each followed
+// by the given sync marker.
+func avroBlockSplitter(sync []byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		d := &avroDecoder{b: data}
+		token, ok, err := d.block(sync)
+		if err != nil {
+			return 0, nil, err
+		}
+		if !ok {
+			if atEOF {
+				return 0, nil, ErrTruncatedAvroData
+			}
+			return 0, nil, nil
+		}
+		return d.off, token, nil
+	}
+}
+
+// DecodeAvroBlock returns the number of objects and the serialized objects of
+// a block returned by the split function of NewAvroOCFSplitter.
+func DecodeAvroBlock(block []byte) (count int64, data []byte, err error) {
+	d := &avroDecoder{b: block}
+	count, ok, err := d.long()
+	if err != nil {
+		return 0, nil, err
+	}
+	if !ok {
+		return 0, nil, ErrTruncatedAvroData
+	}
+	size, ok, err := d.long()
+	if err != nil {
+		return 0, nil, err
+	}
+	if !ok {
+		return 0, nil, ErrTruncatedAvroData
+	}
+	if size != int64(len(block)-d.off) {
+		return 0, nil, ErrInvalidAvroOCF
+	}
+	return count, block[d.off:], nil
+}
@@ -0,0 +1,97 @@
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+var avroSync = []byte("0123456789abcdef")
+
+// avroHeader encodes an avro object container file header for a schema of
+// longs, with the given sync marker.
+func avroHeader(sync []byte) []byte {
+	b := append([]byte(nil), avroMagic...)
+	meta := [][2]string{{"avro.schema", `"long"`}, {"avro.codec", "null"}}
+	b = binary.AppendVarint(b, int64(len(meta)))
+	for _, kv := range meta {
+		for _, s := range kv {
+			b = binary.AppendVarint(b, int64(len(s)))
+			b = append(b, s...)
+		}
+	}
+	b = binary.AppendVarint(b, 0)
+	return append(b, sync...)
+}
+
+// avroBlock encodes a block of longs, followed by the sync marker.
+func avroBlock(sync []byte, values ...int64) []byte {
+	var data []byte
+	for _, v := range values {
+		data = binary.AppendVarint(data, v)
+	}
+	b := binary.AppendVarint(nil, int64(len(values)))
+	b = binary.AppendVarint(b, int64(len(data)))
+	b = append(b, data...)
+	return append(b, sync...)
+}
+
+func TestAvroOCFSplitter(t *testing.T) {
+	var (
+		header = avroHeader(avroSync)
+		file   = bytes.Join([][]byte{
+			header,
+			avroBlock(avroSync, 1, 2, 3),
+			avroBlock(avroSync, 300),
+			avroBlock(avroSync, -1, 0),
+		}, nil)
+	)
+	var cases = []struct {
+		doc    string
+		input  []byte
+		counts []int64
+		err    error
+	}{
+		{doc: "empty input", input: nil, counts: nil},
+		{doc: "header only", input: header, counts: nil},
+		{doc: "three blocks", input: file, counts: []int64{3, 1, 2}},
+		{doc: "invalid magic", input: []byte("Obj\x02xxxx"), err: ErrInvalidAvroOCF},
+		{doc: "truncated header", input: header[:10], err: ErrTruncatedAvroData},
+		{doc: "truncated block", input: file[:len(file)-1], counts: []int64{3, 1}, err: ErrTruncatedAvroData},
+		{
+			doc:    "sync marker mismatch",
+			input:  append(append(header, avroBlock(avroSync, 1)...), avroBlock([]byte("fedcba9876543210"), 2)...),
+			counts: []int64{1},
+			err:    ErrAvroSyncMismatch,
+		},
+	}
+	for _, c := range cases {
+		// Feed one byte at a time, so blocks are split across calls.
+		s := bufio.NewScanner(iotest.OneByteReader(bytes.NewReader(c.input)))
+		s.Split(NewAvroOCFSplitter())
+		var counts []int64
+		for s.Scan() {
+			count, data, err := DecodeAvroBlock(s.Bytes())
+			if err != nil {
+				t.Fatalf("[%s] DecodeAvroBlock: got %v, want nil", c.doc, err)
+			}
+			for i := int64(0); i < count; i++ {
+				_, n := binary.Varint(data)
+				data = data[n:]
+			}
+			if len(data) != 0 {
+				t.Errorf("[%s] got %d trailing bytes in block", c.doc, len(data))
+			}
+			counts = append(counts, count)
+		}
+		if s.Err() != c.err {
+			t.Fatalf("[%s] got %v, want %v", c.doc, s.Err(), c.err)
+		}
+		if !reflect.DeepEqual(counts, c.counts) {
+			t.Errorf("[%s] got %v, want %v", c.doc, counts, c.counts)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package record
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var (
+	ErrInvalidPrefixSize = errors.New("prefix size must be 1, 2, 4 or 8")
+	ErrTruncatedFrame    = errors.New("truncated frame")
+	ErrFrameTooLarge     = errors.New("frame too large")
+)
+
+// NewLengthPrefixedSplitter returns a split function for a stream of length
+// prefixed frames, e.g. a 4-byte big-endian length followed by that many
+// bytes of payload. Each payload, without the prefix, is returned as a token.
+// Input ending in the middle of a frame results in ErrTruncatedFrame.
+func NewLengthPrefixedSplitter(byteOrder binary.ByteOrder, prefixSize int) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if len(data) < prefixSize {
+			if atEOF {
+				return 0, nil, ErrTruncatedFrame
+			}
+			return 0, nil, nil
+		}
+		var size uint64
+		switch prefixSize {
+		case 1:
+			size = uint64(data[0])
+		case 2:
+			size = uint64(byteOrder.Uint16(data))
+		case 4:
+			size = uint64(byteOrder.Uint32(data))
+		case 8:
+			size = byteOrder.Uint64(data)
+		default:
+			return 0, nil, ErrInvalidPrefixSize
+		}
+		if size > uint64(math.MaxInt-prefixSize) {
+			return 0, nil, ErrFrameTooLarge
+		}
+		end := prefixSize + int(size)
+		if len(data) < end {
+			if atEOF {
+				return 0, nil, ErrTruncatedFrame
+			}
+			// Request more data.
+			return 0, nil, nil
+		}
+		return end, data[prefixSize:end], nil
+	}
+}
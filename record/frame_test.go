@@ -0,0 +1,134 @@
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// frames encodes each payload with a length prefix.
+func frames(byteOrder binary.ByteOrder, prefixSize int, payloads ...string) []byte {
+	var buf bytes.Buffer
+	for _, p := range payloads {
+		prefix := make([]byte, 8)
+		switch prefixSize {
+		case 1:
+			prefix[0] = byte(len(p))
+		case 2:
+			byteOrder.PutUint16(prefix, uint16(len(p)))
+		case 4:
+			byteOrder.PutUint32(prefix, uint32(len(p)))
+		case 8:
+			byteOrder.PutUint64(prefix, uint64(len(p)))
+		}
+		buf.Write(prefix[:prefixSize])
+		buf.WriteString(p)
+	}
+	return buf.Bytes()
+}
+
+func TestLengthPrefixedSplitter(t *testing.T) {
+	var cases = []struct {
+		doc        string
+		byteOrder  binary.ByteOrder
+		prefixSize int
+		input      []byte
+		expected   []string
+		err        error
+	}{
+		{
+			doc:        "empty input",
+			byteOrder:  binary.BigEndian,
+			prefixSize: 4,
+			input:      nil,
+			expected:   nil,
+		},
+		{
+			doc:        "multiple frames",
+			byteOrder:  binary.BigEndian,
+			prefixSize: 4,
+			input:      frames(binary.BigEndian, 4, "hello", "", "world!"),
+			expected:   []string{"hello", "", "world!"},
+		},
+		{
+			doc:        "little endian, short prefix",
+			byteOrder:  binary.LittleEndian,
+			prefixSize: 2,
+			input:      frames(binary.LittleEndian, 2, strings.Repeat("x", 300), "y"),
+			expected:   []string{strings.Repeat("x", 300), "y"},
+		},
+		{
+			doc:        "single byte prefix",
+			byteOrder:  binary.BigEndian,
+			prefixSize: 1,
+			input:      frames(binary.BigEndian, 1, "a", "bc"),
+			expected:   []string{"a", "bc"},
+		},
+		{
+			doc:        "eight byte prefix",
+			byteOrder:  binary.BigEndian,
+			prefixSize: 8,
+			input:      frames(binary.BigEndian, 8, "a", "bc"),
+			expected:   []string{"a", "bc"},
+		},
+		{
+			doc:        "truncated payload",
+			byteOrder:  binary.BigEndian,
+			prefixSize: 4,
+			input:      frames(binary.BigEndian, 4, "hello")[:7],
+			expected:   nil,
+			err:        ErrTruncatedFrame,
+		},
+		{
+			doc:        "truncated prefix",
+			byteOrder:  binary.BigEndian,
+			prefixSize: 4,
+			input:      append(frames(binary.BigEndian, 4, "hello"), 0, 0),
+			expected:   []string{"hello"},
+			err:        ErrTruncatedFrame,
+		},
+		{
+			doc:        "invalid prefix size",
+			byteOrder:  binary.BigEndian,
+			prefixSize: 3,
+			input:      []byte("abcdef"),
+			expected:   nil,
+			err:        ErrInvalidPrefixSize,
+		},
+	}
+	for _, c := range cases {
+		// Feed one byte at a time, so frames are split across calls.
+		s := bufio.NewScanner(iotest.OneByteReader(bytes.NewReader(c.input)))
+		s.Split(NewLengthPrefixedSplitter(c.byteOrder, c.prefixSize))
+		var result []string
+		for s.Scan() {
+			result = append(result, s.Text())
+		}
+		if s.Err() != c.err {
+			t.Fatalf("[%s] got %v, want %v", c.doc, s.Err(), c.err)
+		}
+		if !reflect.DeepEqual(result, c.expected) {
+			t.Fatalf("[%s] got %v, want %v", c.doc, result, c.expected)
+		}
+	}
+}
+
+func TestLengthPrefixedSplitterPartialFrame(t *testing.T) {
+	split := NewLengthPrefixedSplitter(binary.BigEndian, 4)
+	data := frames(binary.BigEndian, 4, "hello")
+	for i := 0; i < len(data); i++ {
+		advance, token, err := split(data[:i], false)
+		if advance != 0 || token != nil || err != nil {
+			t.Fatalf("partial frame of %d bytes: got %d, %q, %v, want 0, nil, nil",
+				i, advance, token, err)
+		}
+	}
+	advance, token, err := split(data, false)
+	if advance != len(data) || string(token) != "hello" || err != nil {
+		t.Fatalf("got %d, %q, %v, want %d, hello, nil", advance, token, err, len(data))
+	}
+}
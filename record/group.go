@@ -0,0 +1,49 @@
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+)
+
+var (
+	ErrInvalidGroupSize = errors.New("line group size must be positive")
+	ErrIncompleteGroup  = errors.New("incomplete line group")
+)
+
+// NewLineGroupSplitter returns a split function, that emits groups of exactly
+// n lines as a single token, including line breaks, e.g. four line FASTQ
+// records. The last line of the input may lack a line break. Input ending
+// with fewer than n lines in the last group results in ErrIncompleteGroup, as
+// this usually means truncated data.
+func NewLineGroupSplitter(n int) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if n < 1 {
+			return 0, nil, ErrInvalidGroupSize
+		}
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		var end, lines int
+		for lines < n {
+			i := bytes.IndexByte(data[end:], '\n')
+			if i == -1 {
+				break
+			}
+			end += i + 1
+			lines++
+		}
+		switch {
+		case lines == n:
+			return end, data[:end], nil
+		case !atEOF:
+			// Request more data.
+			return 0, nil, nil
+		case lines == n-1 && end < len(data):
+			// Last line without a line break.
+			return len(data), data, nil
+		default:
+			return 0, nil, ErrIncompleteGroup
+		}
+	}
+}
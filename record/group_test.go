@@ -0,0 +1,75 @@
+package record
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestLineGroupSplitter(t *testing.T) {
+	var cases = []struct {
+		doc      string
+		n        int
+		input    string
+		expected []string
+		err      error
+	}{
+		{
+			doc:      "empty input",
+			n:        4,
+			input:    "",
+			expected: nil,
+		},
+		{
+			doc:   "fastq records",
+			n:     4,
+			input: "@r1\nACGT\n+\nIIII\n@r2\nTTGA\n+\nJJJJ\n",
+			expected: []string{
+				"@r1\nACGT\n+\nIIII\n",
+				"@r2\nTTGA\n+\nJJJJ\n",
+			},
+		},
+		{
+			doc:      "last line without line break",
+			n:        2,
+			input:    "a\nb\nc\nd",
+			expected: []string{"a\nb\n", "c\nd"},
+		},
+		{
+			doc:      "incomplete last group",
+			n:        4,
+			input:    "@r1\nACGT\n+\nIIII\n@r2\nTTGA\n",
+			expected: []string{"@r1\nACGT\n+\nIIII\n"},
+			err:      ErrIncompleteGroup,
+		},
+		{
+			doc:   "invalid group size",
+			n:     0,
+			input: "a\n",
+			err:   ErrInvalidGroupSize,
+		},
+	}
+	for _, c := range cases {
+		for _, oneByte := range []bool{false, true} {
+			var s *bufio.Scanner
+			if oneByte {
+				s = bufio.NewScanner(iotest.OneByteReader(strings.NewReader(c.input)))
+			} else {
+				s = bufio.NewScanner(strings.NewReader(c.input))
+			}
+			s.Split(NewLineGroupSplitter(c.n))
+			var result []string
+			for s.Scan() {
+				result = append(result, s.Text())
+			}
+			if s.Err() != c.err {
+				t.Fatalf("[%s] got %v, want %v", c.doc, s.Err(), c.err)
+			}
+			if !reflect.DeepEqual(result, c.expected) {
+				t.Fatalf("[%s] got %q, want %q", c.doc, result, c.expected)
+			}
+		}
+	}
+}
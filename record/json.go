@@ -0,0 +1,181 @@
+package record
+
+import (
+	"bufio"
+	"errors"
+)
+
+var (
+	ErrInvalidJSON   = errors.New("invalid json")
+	ErrTruncatedJSON = errors.New("truncated json")
+)
+
+// isSpace reports whether c is JSON whitespace.
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// skipSpace returns the index of the first non-whitespace byte in data, or
+// len(data).
+func skipSpace(data []byte) int {
+	for i, c := range data {
+		if !isSpace(c) {
+			return i
+		}
+	}
+	return len(data)
+}
+
+// scanJSONValue returns the length of the JSON value at the start of data, or
+// zero, if data does not contain a complete value yet. Only the structure
+// (brackets, strings and escapes) is inspected, the value is not validated.
+func scanJSONValue(data []byte, atEOF bool) (int, error) {
+	var (
+		depth    int
+		inString bool
+		escaped  bool
+	)
+	switch data[0] {
+	case '{', '[':
+		for i, c := range data {
+			switch {
+			case inString:
+				switch {
+				case escaped:
+					escaped = false
+				case c == '\\':
+					escaped = true
+				case c == '"':
+					inString = false
+				}
+			case c == '"':
+				inString = true
+			case c == '{' || c == '[':
+				depth++
+			case c == '}' || c == ']':
+				depth--
+				if depth == 0 {
+					return i + 1, nil
+				}
+			}
+		}
+	case '"':
+		for i, c := range data[1:] {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				return i + 2, nil
+			}
+		}
+	case '}', ']', ',', ':':
+		return 0, ErrInvalidJSON
+	default:
+		// Numbers, true, false and null end at whitespace or structural
+		// characters.
+		for i, c := range data {
+			switch {
+			case isSpace(c), c == '{', c == '}', c == '[', c == ']', c == ',', c == '"':
+				return i, nil
+			}
+		}
+		if atEOF {
+			return len(data), nil
+		}
+	}
+	if atEOF {
+		return 0, ErrTruncatedJSON
+	}
+	return 0, nil
+}
+
+// NewConcatenatedJSONSplitter returns a split function for a stream of
+// concatenated JSON values, e.g. `{"a": 1} {"a": 2}{"a": 3}`. Values may be
+// separated by any amount of whitespace, including none. Each top-level value
+// is returned as a token, without surrounding whitespace. This complements
+// bufio.ScanLines for newline delimited JSON.
+func NewConcatenatedJSONSplitter() bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		start := skipSpace(data)
+		if start == len(data) {
+			return start, nil, nil
+		}
+		n, err := scanJSONValue(data[start:], atEOF)
+		if err != nil {
+			return 0, nil, err
+		}
+		if n == 0 {
+			// Drop leading whitespace and request more data.
+			return start, nil, nil
+		}
+		return start + n, data[start : start+n], nil
+	}
+}
+
+// scanJSONElement is a split function for a single JSON value at the start of
+// data.
+func scanJSONElement(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	n, err := scanJSONValue(data, atEOF)
+	if err != nil || n == 0 {
+		return 0, nil, err
+	}
+	return n, data[:n], nil
+}
+
+// NewJSONArrayElementSplitter returns a split function for a stream, whose
+// top-level value is a single JSON array, e.g. `[{"a": 1}, {"a": 2}]`. Each
+// element of the array is returned as a token, without the enclosing brackets,
+// separating commas and surrounding whitespace. Nested arrays and objects are
+// returned as a single element. Only whitespace may follow the array, empty
+// input yields no tokens.
+func NewJSONArrayElementSplitter() bufio.SplitFunc {
+	const (
+		start   = iota // before the opening bracket
+		first          // after the opening bracket
+		element        // after an element
+		comma          // after a comma
+		end            // after the closing bracket
+	)
+	state := start
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		// Brackets and commas are consumed together with the next element,
+		// so s only becomes the state, once they are consumed.
+		var i int
+		s := state
+		for {
+			i += skipSpace(data[i:])
+			if i == len(data) {
+				if atEOF && s != start && s != end {
+					return 0, nil, ErrTruncatedJSON
+				}
+				state = s
+				return i, nil, nil
+			}
+			switch c := data[i]; {
+			case s == start && c == '[':
+				s = first
+				i++
+				continue
+			case s == start, s == end:
+				return 0, nil, ErrInvalidJSON
+			case (s == first || s == element) && c == ']':
+				s = end
+				i++
+				continue
+			case s == element && c == ',':
+				s = comma
+				i++
+				continue
+			case s == element:
+				return 0, nil, ErrInvalidJSON
+			}
+			advance, token, err = splitAfter(scanJSONElement, i, data, atEOF)
+			if advance > 0 {
+				state = element
+			}
+			return advance, token, err
+		}
+	}
+}
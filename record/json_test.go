@@ -0,0 +1,173 @@
+package record
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestConcatenatedJSONSplitter(t *testing.T) {
+	var cases = []struct {
+		doc      string
+		input    string
+		expected []string
+		err      error
+	}{
+		{
+			doc:      "empty input",
+			input:    "",
+			expected: nil,
+		},
+		{
+			doc:      "whitespace only",
+			input:    " \n\t ",
+			expected: nil,
+		},
+		{
+			doc:      "objects separated by whitespace",
+			input:    `{"a": 1} {"a": 2}` + "\n\t" + `{"a": 3}`,
+			expected: []string{`{"a": 1}`, `{"a": 2}`, `{"a": 3}`},
+		},
+		{
+			doc:      "objects and arrays without separator",
+			input:    `{"a": [1, 2]}[3, {"b": 4}]{"c": {}}`,
+			expected: []string{`{"a": [1, 2]}`, `[3, {"b": 4}]`, `{"c": {}}`},
+		},
+		{
+			doc:      "brackets and escapes in strings",
+			input:    `{"a": "}]"} {"b": "\"{"}{"c": "\\"}`,
+			expected: []string{`{"a": "}]"}`, `{"b": "\"{"}`, `{"c": "\\"}`},
+		},
+		{
+			doc:      "scalars",
+			input:    `1 "x y" true null{"a": 1}2.5`,
+			expected: []string{`1`, `"x y"`, `true`, `null`, `{"a": 1}`, `2.5`},
+		},
+		{
+			doc:      "truncated object",
+			input:    `{"a": 1} {"a": `,
+			expected: []string{`{"a": 1}`},
+			err:      ErrTruncatedJSON,
+		},
+		{
+			doc:      "stray closing bracket",
+			input:    `{"a": 1} }`,
+			expected: []string{`{"a": 1}`},
+			err:      ErrInvalidJSON,
+		},
+	}
+	for _, c := range cases {
+		for _, oneByte := range []bool{false, true} {
+			var s *bufio.Scanner
+			if oneByte {
+				s = bufio.NewScanner(iotest.OneByteReader(strings.NewReader(c.input)))
+			} else {
+				s = bufio.NewScanner(strings.NewReader(c.input))
+			}
+			s.Split(NewConcatenatedJSONSplitter())
+			var result []string
+			for s.Scan() {
+				result = append(result, s.Text())
+			}
+			if s.Err() != c.err {
+				t.Fatalf("[%s] got %v, want %v", c.doc, s.Err(), c.err)
+			}
+			if !reflect.DeepEqual(result, c.expected) {
+				t.Fatalf("[%s] got %v, want %v", c.doc, result, c.expected)
+			}
+		}
+	}
+}
+
+func TestJSONArrayElementSplitter(t *testing.T) {
+	var cases = []struct {
+		doc      string
+		input    string
+		expected []string
+		err      error
+	}{
+		{
+			doc:      "empty input",
+			input:    "",
+			expected: nil,
+		},
+		{
+			doc:      "empty array",
+			input:    " [ ]\n",
+			expected: nil,
+		},
+		{
+			doc:      "array of objects",
+			input:    `[{"a": 1}, {"a": 2},` + "\n" + `{"a": 3}]`,
+			expected: []string{`{"a": 1}`, `{"a": 2}`, `{"a": 3}`},
+		},
+		{
+			doc:      "nested values and strings",
+			input:    `[{"a": [1, {"b": "],"}]}, [[2], []], "x,]y"]`,
+			expected: []string{`{"a": [1, {"b": "],"}]}`, `[[2], []]`, `"x,]y"`},
+		},
+		{
+			doc:      "scalars",
+			input:    `[1,true , null,2.5]`,
+			expected: []string{`1`, `true`, `null`, `2.5`},
+		},
+		{
+			doc:   "not an array",
+			input: `{"a": 1}`,
+			err:   ErrInvalidJSON,
+		},
+		{
+			doc:      "missing comma",
+			input:    `[1, 2 3]`,
+			expected: []string{`1`, `2`},
+			err:      ErrInvalidJSON,
+		},
+		{
+			doc:      "trailing comma",
+			input:    `[1, 2, ]`,
+			expected: []string{`1`, `2`},
+			err:      ErrInvalidJSON,
+		},
+		{
+			doc:      "data after array",
+			input:    `[1] 2`,
+			expected: []string{`1`},
+			err:      ErrInvalidJSON,
+		},
+		{
+			doc:      "truncated array",
+			input:    `[{"a": 1}, {"a": `,
+			expected: []string{`{"a": 1}`},
+			err:      ErrTruncatedJSON,
+		},
+		{
+			doc:      "missing closing bracket",
+			input:    `[1, 2`,
+			expected: []string{`1`, `2`},
+			err:      ErrTruncatedJSON,
+		},
+	}
+	for _, c := range cases {
+		for _, oneByte := range []bool{false, true} {
+			var s *bufio.Scanner
+			if oneByte {
+				s = bufio.NewScanner(iotest.OneByteReader(strings.NewReader(c.input)))
+			} else {
+				s = bufio.NewScanner(strings.NewReader(c.input))
+			}
+			s.Split(NewJSONArrayElementSplitter())
+			var result []string
+			for s.Scan() {
+				result = append(result, s.Text())
+			}
+			if s.Err() != c.err {
+				t.Fatalf("[%s] got %v, want %v", c.doc, s.Err(), c.err)
+			}
+			if !reflect.DeepEqual(result, c.expected) {
+				t.Fatalf("[%s] got %v, want %v", c.doc, result, c.expected)
+			}
+		}
+	}
+}
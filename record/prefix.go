@@ -0,0 +1,29 @@
+package record
+
+import (
+	"bufio"
+	"bytes"
+)
+
+// NewDelimiterPrefixSplitter returns a split function for multi-line records,
+// where a new record starts with each line beginning with prefix, e.g. a
+// timestamp in a log file. All following lines not starting with prefix, like
+// a stack trace, belong to the current record. Lines before the first line
+// starting with prefix form a record of their own. Tokens include their line
+// breaks.
+func NewDelimiterPrefixSplitter(prefix []byte) bufio.SplitFunc {
+	delim := append([]byte("\n"), prefix...)
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if i := bytes.Index(data, delim); i >= 0 {
+			return i + 1, data[:i+1], nil
+		}
+		if atEOF {
+			return len(data), data, nil
+		}
+		// Request more data.
+		return 0, nil, nil
+	}
+}
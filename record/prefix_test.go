@@ -0,0 +1,75 @@
+package record
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDelimiterPrefixSplitter(t *testing.T) {
+	var cases = []struct {
+		doc      string
+		prefix   string
+		input    string
+		expected []string
+	}{
+		{
+			doc:      "empty input",
+			prefix:   "2024-",
+			input:    "",
+			expected: nil,
+		},
+		{
+			doc:    "multi-line records",
+			prefix: "2024-",
+			input: `2024-01-01 10:00:00 INFO started
+2024-01-01 10:00:01 ERROR failed
+Traceback (most recent call last):
+  File "main.py", line 1, in <module>
+ZeroDivisionError: division by zero
+2024-01-01 10:00:02 INFO done
+`,
+			expected: []string{
+				"2024-01-01 10:00:00 INFO started\n",
+				"2024-01-01 10:00:01 ERROR failed\nTraceback (most recent call last):\n" +
+					"  File \"main.py\", line 1, in <module>\nZeroDivisionError: division by zero\n",
+				"2024-01-01 10:00:02 INFO done\n",
+			},
+		},
+		{
+			doc:      "preamble and unterminated last record",
+			prefix:   "2024-",
+			input:    "preamble\n2024-01-01 a\n  b\n2024-01-02 c",
+			expected: []string{"preamble\n", "2024-01-01 a\n  b\n", "2024-01-02 c"},
+		},
+		{
+			doc:      "prefix in the middle of a line",
+			prefix:   "2024-",
+			input:    "2024-01-01 see 2024-01-02\n2024-01-03\n",
+			expected: []string{"2024-01-01 see 2024-01-02\n", "2024-01-03\n"},
+		},
+	}
+	for _, c := range cases {
+		for _, oneByte := range []bool{false, true} {
+			var s *bufio.Scanner
+			if oneByte {
+				s = bufio.NewScanner(iotest.OneByteReader(strings.NewReader(c.input)))
+			} else {
+				s = bufio.NewScanner(strings.NewReader(c.input))
+			}
+			s.Split(NewDelimiterPrefixSplitter([]byte(c.prefix)))
+			var result []string
+			for s.Scan() {
+				result = append(result, s.Text())
+			}
+			if s.Err() != nil {
+				t.Fatalf("[%s] got %v, want nil", c.doc, s.Err())
+			}
+			if !reflect.DeepEqual(result, c.expected) {
+				t.Fatalf("[%s] got %q, want %q", c.doc, result, c.expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package record
+
+import (
+	"bufio"
+	"bytes"
+)
+
+// bom is the UTF-8 byte order mark.
+var bom = []byte("\xef\xbb\xbf")
+
+// splitAfter calls split on data after a prefix of n bytes, like a byte order
+// mark, a header or the brackets of a JSON array, and consumes the prefix
+// together with the token. A bufio.Scanner stops at the end of the input,
+// once a split function returns no token, even if it advanced, so a prefix
+// consumed on its own would drop the rest of the input. If split requests
+// more data, nothing is consumed, and the prefix is seen again on the next
+// call.
+func splitAfter(split bufio.SplitFunc, n int, data []byte, atEOF bool) (advance int, token []byte, err error) {
+	advance, token, err = split(data[n:], atEOF)
+	if advance == 0 && token == nil {
+		return 0, nil, err
+	}
+	return n + advance, token, err
+}
+
+// StripBOM wraps a split function, removing a UTF-8 byte order mark at the
+// start of the input.
+func StripBOM(split bufio.SplitFunc) bufio.SplitFunc {
+	done := false
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if done {
+			return split(data, atEOF)
+		}
+		if !atEOF && len(data) < len(bom) && bytes.HasPrefix(bom, data) {
+			// Request more data.
+			return 0, nil, nil
+		}
+		if !bytes.HasPrefix(data, bom) {
+			done = true
+			return split(data, atEOF)
+		}
+		advance, token, err = splitAfter(split, len(bom), data, atEOF)
+		done = advance > 0
+		return advance, token, err
+	}
+}
+
+// SkipLines wraps a split function, skipping the first n lines of the input,
+// e.g. a header. Lines end with a newline, a last line without newline is
+// skipped as well.
+func SkipLines(split bufio.SplitFunc, n int) bufio.SplitFunc {
+	var skipped int
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		// Lines are only counted as skipped, once they are consumed.
+		var i, k int
+		for k = skipped; k < n; k++ {
+			j := bytes.IndexByte(data[i:], '\n')
+			if j < 0 {
+				if !atEOF {
+					skipped = k
+					return i, nil, nil
+				}
+				i, k = len(data), n
+				break
+			}
+			i += j + 1
+		}
+		if i == len(data) && !atEOF {
+			skipped = k
+			return i, nil, nil
+		}
+		advance, token, err = splitAfter(split, i, data, atEOF)
+		if advance > 0 {
+			skipped = k
+		}
+		return advance, token, err
+	}
+}
@@ -0,0 +1,105 @@
+package record
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestPrologue(t *testing.T) {
+	var cases = []struct {
+		doc      string
+		split    func() bufio.SplitFunc
+		input    string
+		expected []string
+	}{
+		{
+			doc: "bom and header before xml",
+			split: func() bufio.SplitFunc {
+				ts := &TagSplitter{Tag: "a", MaxBytesApprox: 1}
+				return StripBOM(SkipLines(ts.Split, 2))
+			},
+			input:    "\xef\xbb\xbfid <a>0</a>\n# <a>0</a>\n<r><a>1</a><a>2</a></r>",
+			expected: []string{"<a>1</a>", "<a>2</a>"},
+		},
+		{
+			doc: "bom only",
+			split: func() bufio.SplitFunc {
+				return StripBOM(bufio.ScanLines)
+			},
+			input:    "\xef\xbb\xbfa\nb\n",
+			expected: []string{"a", "b"},
+		},
+		{
+			doc: "bom before a single line without newline",
+			split: func() bufio.SplitFunc {
+				return StripBOM(bufio.ScanLines)
+			},
+			input:    "\xef\xbb\xbfa",
+			expected: []string{"a"},
+		},
+		{
+			doc: "bom before skipped lines",
+			split: func() bufio.SplitFunc {
+				return StripBOM(SkipLines(bufio.ScanLines, 1))
+			},
+			input:    "\xef\xbb\xbfheader\nab",
+			expected: []string{"ab"},
+		},
+		{
+			doc: "no bom",
+			split: func() bufio.SplitFunc {
+				return StripBOM(bufio.ScanLines)
+			},
+			input:    "a\nb\xef\xbb\xbf\n",
+			expected: []string{"a", "b\xef\xbb\xbf"},
+		},
+		{
+			doc: "short input",
+			split: func() bufio.SplitFunc {
+				return StripBOM(bufio.ScanLines)
+			},
+			input:    "\xef",
+			expected: []string{"\xef"},
+		},
+		{
+			doc: "skip lines",
+			split: func() bufio.SplitFunc {
+				return SkipLines(bufio.ScanLines, 1)
+			},
+			input:    "header\na\nb",
+			expected: []string{"a", "b"},
+		},
+		{
+			doc: "skip more lines than input",
+			split: func() bufio.SplitFunc {
+				return SkipLines(bufio.ScanLines, 3)
+			},
+			input:    "header\na",
+			expected: nil,
+		},
+	}
+	for _, c := range cases {
+		for _, oneByte := range []bool{false, true} {
+			var s *bufio.Scanner
+			if oneByte {
+				s = bufio.NewScanner(iotest.OneByteReader(strings.NewReader(c.input)))
+			} else {
+				s = bufio.NewScanner(strings.NewReader(c.input))
+			}
+			s.Split(c.split())
+			var result []string
+			for s.Scan() {
+				result = append(result, s.Text())
+			}
+			if err := s.Err(); err != nil {
+				t.Fatalf("[%s] got %v, want nil", c.doc, err)
+			}
+			if !reflect.DeepEqual(result, c.expected) {
+				t.Fatalf("[%s] got %q, want %q", c.doc, result, c.expected)
+			}
+		}
+	}
+}
@@ -1,17 +1,52 @@
-// Package scan accepts a bufio.SplitFunc and generalizes batches to non-line oriented input, e.g. XML.
+// Package record accepts a bufio.SplitFunc and generalizes batches to non-line oriented input, e.g. XML.
 package record
 
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"runtime"
 	"sync"
+	"sync/atomic"
 )
 
+// MaxWorkers is the maximum number of workers a processor accepts.
+const MaxWorkers = 65536
+
+// ErrInvalidWorkers is returned, if the number of workers is not between one
+// and MaxWorkers.
+var ErrInvalidWorkers = errors.New("number of workers must be between 1 and MaxWorkers")
+
+// OversizedTokenPolicy decides what happens with a token exceeding
+// MaxTokenSize.
+type OversizedTokenPolicy int
+
+const (
+	// OversizedTokenError stops processing with bufio.ErrTooLong.
+	OversizedTokenError OversizedTokenPolicy = iota
+	// OversizedTokenSkip drops the token.
+	OversizedTokenSkip
+	// OversizedTokenTruncate passes the first MaxTokenSize bytes of the token
+	// and drops the rest.
+	OversizedTokenTruncate
+)
+
+// Stats are collected during a run.
+type Stats struct {
+	// SkippedTokens and TruncatedTokens count oversized tokens.
+	SkippedTokens   int64
+	TruncatedTokens int64
+}
+
 // Processor can process records in parallel. Records can be specified by a
 // split function that is used internally by a bufio.Scanner.
+//
+// A split function signals the end of the records by returning 0, nil, nil
+// at EOF, as usual, or by returning io.EOF, like TagSplitter does. Both end
+// the run cleanly, io.EOF is never returned from Run. A token returned
+// together with io.EOF is still processed, like with bufio.ErrFinalToken.
 type Processor struct {
 	BatchSize  int
 	SplitFunc  bufio.SplitFunc
@@ -20,6 +55,80 @@ type Processor struct {
 	R          io.Reader
 	W          io.Writer
 	F          func([]byte) ([]byte, error)
+	// MaxTokenSize is the maximum size of a token, defaults to
+	// bufio.MaxScanTokenSize. Split functions with their own buffer, like
+	// TagSplitter, are not affected.
+	MaxTokenSize int
+	// OnOversizedToken is the policy for tokens exceeding MaxTokenSize. To
+	// skip or truncate a token, the rest of the token is dropped, which is
+	// found by calling the split function on the remaining input. This works
+	// for line or delimiter based split functions, but not for formats that
+	// cannot resynchronize, like length prefixed frames.
+	OnOversizedToken OversizedTokenPolicy
+
+	skipped   atomic.Int64
+	truncated atomic.Int64
+}
+
+// Stats returns statistics about the current or last run.
+func (p *Processor) Stats() Stats {
+	return Stats{
+		SkippedTokens:   p.skipped.Load(),
+		TruncatedTokens: p.truncated.Load(),
+	}
+}
+
+// maxTokenSize returns the maximum token size.
+func (p *Processor) maxTokenSize() int {
+	if p.MaxTokenSize > 0 {
+		return p.MaxTokenSize
+	}
+	return bufio.MaxScanTokenSize
+}
+
+// splitFunc wraps a split function to apply the oversized token policy.
+func (p *Processor) splitFunc(split bufio.SplitFunc) bufio.SplitFunc {
+	var (
+		max      = p.maxTokenSize()
+		dropping bool // dropping the rest of an oversized token
+	)
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		advance, token, err = split(data, atEOF)
+		if err == io.EOF && token != nil {
+			// The scanner would drop the token.
+			return advance, token, bufio.ErrFinalToken
+		}
+		if err != nil {
+			return advance, token, err
+		}
+		if dropping {
+			switch {
+			case token != nil:
+				// This is the rest of the oversized token.
+				dropping = false
+				return advance, nil, nil
+			case advance == 0:
+				// No end in sight, drop everything.
+				return len(data), nil, nil
+			}
+			return advance, token, err
+		}
+		if advance > 0 || token != nil || atEOF || len(data) < max {
+			return advance, token, err
+		}
+		switch p.OnOversizedToken {
+		case OversizedTokenSkip:
+			p.skipped.Add(1)
+			dropping = true
+			return len(data), nil, nil
+		case OversizedTokenTruncate:
+			p.truncated.Add(1)
+			dropping = true
+			return len(data), data[:max], nil
+		default:
+			return 0, nil, bufio.ErrTooLong
+		}
+	}
 }
 
 // NewProcessor creates a new record processor.
@@ -41,11 +150,30 @@ func (p *Processor) Split(f bufio.SplitFunc) {
 
 // Run starts the workers, crunching through the input.
 func (p *Processor) Run() error {
+	if p.SplitFunc == nil {
+		return fmt.Errorf("split function required")
+	}
+	return p.run([]io.Reader{p.R}, func() bufio.SplitFunc { return p.SplitFunc })
+}
+
+// RunShards is like Run, but reads multiple inputs concurrently, e.g. the
+// shards of a large XML dump, with one reader per input. All readers share
+// the workers and write to W. Split functions like TagSplitter keep state,
+// so newSplit is called once per input, R and SplitFunc are not used.
+// Batches never mix records from different inputs.
+func (p *Processor) RunShards(rs []io.Reader, newSplit func() bufio.SplitFunc) error {
+	return p.run(rs, newSplit)
+}
+
+// run processes all inputs, each with its own reader goroutine.
+func (p *Processor) run(rs []io.Reader, newSplit func() bufio.SplitFunc) error {
+	if p.NumWorkers < 1 || p.NumWorkers > MaxWorkers {
+		return ErrInvalidWorkers
+	}
 	// wErr signals a worker or writer error. If an error occurs, the items in
-	// the queue are still process, just no items are added to the queue. There
-	// is only one way to toggle this, from nil to non-nil, so we don't care
-	// about synchronisation.
-	var wErr error
+	// the queue are still process, just no items are added to the queue. It
+	// is read by all reader goroutines, so it needs synchronisation.
+	var wErr firstError
 	// worker takes []byte batches from a channel queue, executes f and sends
 	// the result to the out channel.
 	worker := func(queue chan []byte, out chan []byte, f func([]byte) ([]byte, error), wg *sync.WaitGroup) {
@@ -53,7 +181,7 @@ func (p *Processor) Run() error {
 		for batch := range queue {
 			r, err := f(batch)
 			if err != nil {
-				wErr = err
+				wErr.set(err)
 			}
 			out <- r
 		}
@@ -63,11 +191,11 @@ func (p *Processor) Run() error {
 		bw := bufio.NewWriter(w)
 		for b := range bc {
 			if _, err := bw.Write(b); err != nil {
-				wErr = err
+				wErr.set(err)
 			}
 		}
 		if err := bw.Flush(); err != nil {
-			wErr = err
+			wErr.set(err)
 		}
 		done <- true
 	}
@@ -83,36 +211,82 @@ func (p *Processor) Run() error {
 	for i := 0; i < p.NumWorkers; i++ {
 		go worker(queue, out, p.F, &wg)
 	}
-	// setup scanner with custom split function
-	scanner := bufio.NewScanner(p.R)
-	if p.SplitFunc == nil {
-		return fmt.Errorf("split function required")
+	// reader batches the tokens of a single input, it returns the scanner
+	// error.
+	reader := func(r io.Reader, split bufio.SplitFunc) error {
+		// setup scanner with custom split function
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, p.maxTokenSize())
+		scanner.Split(p.splitFunc(split))
+		var (
+			buf bytes.Buffer
+			i   int
+		)
+		for scanner.Scan() {
+			if i == p.BatchSize {
+				// To avoid checking on each loop, we only check for worker or
+				// write errors here.
+				if wErr.get() != nil {
+					break
+				}
+				b := make([]byte, buf.Len())
+				copy(b, buf.Bytes())
+				queue <- b
+				buf.Reset()
+				i = 0
+			}
+			buf.Write(scanner.Bytes())
+			i++
+		}
+		if buf.Len() > 0 {
+			queue <- buf.Bytes() // no other modification
+		}
+		return scanner.Err()
 	}
-	scanner.Split(p.SplitFunc)
+	p.skipped.Store(0)
+	p.truncated.Store(0)
 	var (
-		buf bytes.Buffer
-		i   int
+		readers sync.WaitGroup
+		errs    = make([]error, len(rs))
 	)
-	for scanner.Scan() {
-		if i == p.BatchSize {
-			// To avoid checking on each loop, we only check for worker or
-			// write errors here.
-			if wErr != nil {
-				break
-			}
-			b := make([]byte, buf.Len())
-			copy(b, buf.Bytes())
-			queue <- b
-			buf.Reset()
-			i = 0
-		}
-		buf.Write(scanner.Bytes())
-		i++
+	readers.Add(len(rs))
+	for i, r := range rs {
+		go func(i int, r io.Reader) {
+			defer readers.Done()
+			errs[i] = reader(r, newSplit())
+		}(i, r)
 	}
-	queue <- buf.Bytes() // no other modification
+	readers.Wait()
 	close(queue)
 	wg.Wait()
 	close(out)
 	<-done
-	return wErr
+	for _, err := range errs {
+		if err != nil {
+			wErr.set(err)
+		}
+	}
+	return wErr.get()
+}
+
+// firstError keeps the first error reported by any goroutine.
+type firstError struct {
+	mu  sync.Mutex
+	err error
+}
+
+// set records err, if no error has been recorded yet.
+func (e *firstError) set(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+// get returns the first recorded error, if any.
+func (e *firstError) get() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.err
 }
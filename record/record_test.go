@@ -1,9 +1,15 @@
 package record
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"reflect"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"testing"
 )
 
@@ -46,3 +52,229 @@ func TestProcessor(t *testing.T) {
 		}
 	}
 }
+
+func TestProcessorSplitEOF(t *testing.T) {
+	errSplit := errors.New("split failed")
+	var cases = []struct {
+		about  string
+		final  func(data []byte) (int, []byte, error)
+		result string
+		err    error
+	}{
+		{
+			about: "io.EOF without token",
+			final: func(data []byte) (int, []byte, error) {
+				return 0, nil, io.EOF
+			},
+			result: "aaa bbb ",
+		},
+		{
+			about: "io.EOF with final token",
+			final: func(data []byte) (int, []byte, error) {
+				return len(data), data, io.EOF
+			},
+			result: "aaa bbb cc",
+		},
+		{
+			about: "no more tokens",
+			final: func(data []byte) (int, []byte, error) {
+				return 0, nil, nil
+			},
+			result: "aaa bbb ",
+		},
+		{
+			about: "split error",
+			final: func(data []byte) (int, []byte, error) {
+				return 0, nil, errSplit
+			},
+			err: errSplit,
+		},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		p := NewProcessor(strings.NewReader("aaa bbb cc"), &buf, func(p []byte) ([]byte, error) {
+			return p, nil
+		})
+		p.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+			if len(data) >= 4 {
+				return 4, data[:4], nil
+			}
+			if atEOF {
+				return c.final(data)
+			}
+			return 0, nil, nil
+		})
+		p.NumWorkers = 1
+		if err := p.Run(); err != c.err {
+			t.Fatalf("[%s] got %v, want %v", c.about, err, c.err)
+		}
+		if c.err == nil && buf.String() != c.result {
+			t.Errorf("[%s] got %q, want %q", c.about, buf.String(), c.result)
+		}
+	}
+}
+
+func TestProcessorInvalidWorkers(t *testing.T) {
+	for _, n := range []int{0, -1, MaxWorkers + 1} {
+		p := NewProcessor(strings.NewReader("a\n"), io.Discard, func(p []byte) ([]byte, error) {
+			return p, nil
+		})
+		p.NumWorkers = n
+		if err := p.Run(); err != ErrInvalidWorkers {
+			t.Errorf("got %v, want %v", err, ErrInvalidWorkers)
+		}
+	}
+}
+
+func TestProcessorNoTokens(t *testing.T) {
+	var calls int32
+	var buf bytes.Buffer
+	p := NewProcessor(strings.NewReader("no tokens here"), &buf, func(p []byte) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		if len(p) == 0 {
+			return nil, fmt.Errorf("transformer called with empty input")
+		}
+		return p, nil
+	})
+	p.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		// Consume everything, never yield a token.
+		return len(data), nil, nil
+	})
+	if err := p.Run(); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 0 {
+		t.Fatalf("transformer called %d times, want 0", n)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("got %q, want empty output", buf.String())
+	}
+}
+
+func TestProcessorOversizedToken(t *testing.T) {
+	input := "short\n" + strings.Repeat("x", 40) + "\nend\n"
+	var cases = []struct {
+		about    string
+		policy   OversizedTokenPolicy
+		expected string
+		stats    Stats
+		err      error
+	}{
+		{
+			about:  "error",
+			policy: OversizedTokenError,
+			err:    bufio.ErrTooLong,
+		},
+		{
+			about:    "skip",
+			policy:   OversizedTokenSkip,
+			expected: "short\nend\n",
+			stats:    Stats{SkippedTokens: 1},
+		},
+		{
+			about:    "truncate",
+			policy:   OversizedTokenTruncate,
+			expected: "short\n" + strings.Repeat("x", 16) + "\nend\n",
+			stats:    Stats{TruncatedTokens: 1},
+		},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		p := NewProcessor(strings.NewReader(input), &buf, func(p []byte) ([]byte, error) {
+			return append(p, '\n'), nil
+		})
+		p.NumWorkers = 1
+		p.BatchSize = 1
+		p.MaxTokenSize = 16
+		p.OnOversizedToken = c.policy
+		if err := p.Run(); err != c.err {
+			t.Fatalf("[%s] got %v, want %v", c.about, err, c.err)
+		}
+		if c.err != nil {
+			continue
+		}
+		if buf.String() != c.expected {
+			t.Errorf("[%s] got %q, want %q", c.about, buf.String(), c.expected)
+		}
+		if p.Stats() != c.stats {
+			t.Errorf("[%s] got %+v, want %+v", c.about, p.Stats(), c.stats)
+		}
+	}
+}
+
+func TestProcessorExactMultiple(t *testing.T) {
+	var (
+		batches atomic.Int32
+		buf     bytes.Buffer
+	)
+	p := NewProcessor(strings.NewReader("a\nb\nc\nd\ne\nf\n"), &buf, func(p []byte) ([]byte, error) {
+		batches.Add(1)
+		if len(p) == 0 {
+			return nil, fmt.Errorf("transformer called with empty batch")
+		}
+		return p, nil
+	})
+	p.BatchSize = 3
+	if err := p.Run(); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if n := batches.Load(); n != 2 {
+		t.Errorf("got %d batches, want 2", n)
+	}
+	if buf.Len() != 6 {
+		t.Errorf("got %d bytes, want 6", buf.Len())
+	}
+}
+
+func TestProcessorRunShards(t *testing.T) {
+	shards := []string{
+		`<?xml version="1.0"?><r><a>1</a><a>2</a><a>3</a></r>`,
+		`<r><a>4</a></r>`,
+		`<r><a>5</a><b>x</b><a>6</a></r>`,
+	}
+	var rs []io.Reader
+	for _, s := range shards {
+		rs = append(rs, strings.NewReader(s))
+	}
+	var buf bytes.Buffer
+	p := NewProcessor(nil, &buf, func(p []byte) ([]byte, error) {
+		return append(p, '\n'), nil
+	})
+	p.BatchSize = 1
+	p.NumWorkers = 4
+	err := p.RunShards(rs, func() bufio.SplitFunc {
+		ts := &TagSplitter{Tag: "a", MaxBytesApprox: 1}
+		return ts.Split
+	})
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	result := strings.Fields(buf.String())
+	sort.Strings(result)
+	expected := []string{"<a>1</a>", "<a>2</a>", "<a>3</a>", "<a>4</a>", "<a>5</a>", "<a>6</a>"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("got %v, want %v", result, expected)
+	}
+}
+
+func TestProcessorRunShardsError(t *testing.T) {
+	// All readers check for worker errors, while workers fail, which must
+	// not race.
+	var rs []io.Reader
+	for i := 0; i < 8; i++ {
+		rs = append(rs, strings.NewReader(strings.Repeat("<a>1</a>", 1000)))
+	}
+	errFailed := errors.New("failed")
+	p := NewProcessor(nil, io.Discard, func(p []byte) ([]byte, error) {
+		return nil, errFailed
+	})
+	p.BatchSize = 1
+	p.NumWorkers = 4
+	err := p.RunShards(rs, func() bufio.SplitFunc {
+		ts := &TagSplitter{Tag: "a", MaxBytesApprox: 1}
+		return ts.Split
+	})
+	if err != errFailed {
+		t.Fatalf("got %v, want %v", err, errFailed)
+	}
+}
@@ -0,0 +1,37 @@
+package record
+
+import (
+	"bufio"
+	"errors"
+	"regexp"
+)
+
+var ErrEmptyMatch = errors.New("separator regexp matched the empty string")
+
+// NewRegexpSplitter returns a split function, that uses matches of re as
+// record separators and emits the bytes between matches as tokens, without
+// the separators. Use multi-line mode to match lines, e.g. `(?m)^---\n`. A
+// match ending at the end of the available data is only used once more data
+// has been read, as it may continue. Empty tokens are skipped and a regexp
+// matching the empty string results in ErrEmptyMatch.
+func NewRegexpSplitter(re *regexp.Regexp) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		loc := re.FindIndex(data)
+		switch {
+		case loc != nil && loc[0] == loc[1]:
+			return 0, nil, ErrEmptyMatch
+		case loc != nil && (loc[1] < len(data) || atEOF):
+			if loc[0] == 0 {
+				return loc[1], nil, nil
+			}
+			return loc[1], data[:loc[0]], nil
+		case atEOF:
+			return len(data), data, nil
+		}
+		// Request more data.
+		return 0, nil, nil
+	}
+}
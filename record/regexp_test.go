@@ -0,0 +1,72 @@
+package record
+
+import (
+	"bufio"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestRegexpSplitter(t *testing.T) {
+	var cases = []struct {
+		doc      string
+		re       string
+		input    string
+		expected []string
+		err      error
+	}{
+		{
+			doc:      "empty input",
+			re:       `(?m)^---$\n?`,
+			input:    "",
+			expected: nil,
+		},
+		{
+			doc:      "documents separated by dashes",
+			re:       `(?m)^---$\n?`,
+			input:    "---\na: 1\n---\nb: 2\nc: 3\n---\nd: 4\n",
+			expected: []string{"a: 1\n", "b: 2\nc: 3\n", "d: 4\n"},
+		},
+		{
+			doc:      "dashes inside a line do not split",
+			re:       `(?m)^---$\n?`,
+			input:    "a: ---\n---\nb: 2\n---",
+			expected: []string{"a: ---\n", "b: 2\n"},
+		},
+		{
+			doc:      "no match",
+			re:       `(?m)^===`,
+			input:    "a\nb\n",
+			expected: []string{"a\nb\n"},
+		},
+		{
+			doc:   "empty match",
+			re:    `x*`,
+			input: "a\nb\n",
+			err:   ErrEmptyMatch,
+		},
+	}
+	for _, c := range cases {
+		for _, oneByte := range []bool{false, true} {
+			var s *bufio.Scanner
+			if oneByte {
+				s = bufio.NewScanner(iotest.OneByteReader(strings.NewReader(c.input)))
+			} else {
+				s = bufio.NewScanner(strings.NewReader(c.input))
+			}
+			s.Split(NewRegexpSplitter(regexp.MustCompile(c.re)))
+			var result []string
+			for s.Scan() {
+				result = append(result, s.Text())
+			}
+			if s.Err() != c.err {
+				t.Fatalf("[%s] got %v, want %v", c.doc, s.Err(), c.err)
+			}
+			if !reflect.DeepEqual(result, c.expected) {
+				t.Fatalf("[%s] got %q, want %q", c.doc, result, c.expected)
+			}
+		}
+	}
+}
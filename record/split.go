@@ -17,6 +17,17 @@ const (
 	// found in the stream.
 	internalBufferPruneLimit = 16384      // bytes
 	maxBufSize               = 1073741824 // 1GB (please send me real-world XML where an element exceeds 1GB -- I think they exist)
+	// defaultSuffixArrayMinSize is the buffer size from which on tags are
+	// looked up with a suffix array. In BenchmarkTagSplitterLookup,
+	// bytes.Index is faster for all sizes measured, up to 128K, both for
+	// large elements and for many small ones, so only very large buffers use
+	// the suffix array.
+	defaultSuffixArrayMinSize = 16777216
+	// smallBatchWarnElements is the number of elements observed before
+	// warning about a small MaxBytesApprox, smallBatchWarnFactor the factor
+	// by which the average element must exceed MaxBytesApprox.
+	smallBatchWarnElements = 100
+	smallBatchWarnFactor   = 10
 )
 
 var (
@@ -24,10 +35,16 @@ var (
 	ErrGarbledInput             = errors.New("likely gabled input")
 	ErrNestedTagsNotImplemented = errors.New("nested tags with the same name not implemented yet")
 	ErrMaxBufSizeExceeded       = errors.New("max buf size exceeded (data may not be valid xml)")
+	ErrNoiseBetweenElements     = errors.New("non-whitespace content between elements")
 
 	errOpenTagNotFound = errors.New("open tag not found")
 )
 
+// Logger is a minimal logging interface, satisfied by *log.Logger.
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
 // TagSplitter splits input on XML elements. It will batch content up to
 // approximately MaxBytesApprox bytes. It is guaranteed that each batch
 // contains at least one complete element content.
@@ -38,6 +55,26 @@ type TagSplitter struct {
 	// MaxBytesApprox is the approximate number of bytes in a batch. A batch
 	// will always contain at least one element, which may exceed this number.
 	MaxBytesApprox uint
+	// Separator is written between elements within a batch, e.g. a newline.
+	// It is not written before the first or after the last element.
+	Separator []byte
+	// OpenTagFilter, if set, is called with the opening tag of each element,
+	// e.g. `<record type="article">`. Elements, for which it returns false,
+	// are skipped.
+	OpenTagFilter func(openTag []byte) bool
+	// StrictBetweenElements fails with ErrNoiseBetweenElements, if anything
+	// but whitespace appears between the end of an element and the start of
+	// the next one. Content before the first and after the last element,
+	// like the root element, is still ignored.
+	StrictBetweenElements bool
+	// SuffixArrayMinSize is the internal buffer size, from which on tags are
+	// looked up with a suffix array. Smaller buffers are searched with
+	// bytes.Index, which does not allocate. Zero means a default size.
+	SuffixArrayMinSize int
+	// Logger, if set, receives a warning, if the average element is much
+	// larger than MaxBytesApprox, so that every element becomes a batch of
+	// its own, which is slow and usually a sign of a misconfiguration.
+	Logger Logger
 
 	// buf is the internal scratch space that is used to find a complete
 	// element. This buffer will grow as large as required to accomodate a tag.
@@ -47,6 +84,15 @@ type TagSplitter struct {
 	batch bytes.Buffer
 	// done signals when there is nothing more to return.
 	done bool
+	// seen is true after the first element has been found; noise is true,
+	// if non-whitespace has been pruned from the buffer since.
+	seen  bool
+	noise bool
+	// elements and elementBytes count the elements batched, for the small
+	// batch warning, which is logged at most once.
+	elements     int
+	elementBytes int
+	warned       bool
 	// once for initializing the opening and closing tag byte slices; the
 	// closing tag to look for (this does not change); opening tags variants,
 	// e.g. '<a>', and '<a '; previously, these were assembled as needed, but
@@ -97,9 +143,47 @@ func (s *TagSplitter) pruneBuf(data []byte) {
 		return
 	}
 	k := int(len(s.buf) / 2)
+	if s.seen && skipSpace(s.buf[:k]) < k {
+		s.noise = true
+	}
 	s.buf = s.buf[k:]
 }
 
+// suffixArrayMinSize returns the buffer size, from which on a suffix array
+// is used.
+func (s *TagSplitter) suffixArrayMinSize() int {
+	if s.SuffixArrayMinSize <= 0 {
+		return defaultSuffixArrayMinSize
+	}
+	return s.SuffixArrayMinSize
+}
+
+// lookup returns a function, that returns the unsorted indices of all
+// occurrences of a tag in the internal buffer.
+func (s *TagSplitter) lookup() func(tag []byte) []int {
+	if len(s.buf) >= s.suffixArrayMinSize() {
+		index := suffixarray.New(s.buf)
+		return func(tag []byte) []int {
+			return index.Lookup(tag, -1)
+		}
+	}
+	return func(tag []byte) []int {
+		return indexAll(s.buf, tag)
+	}
+}
+
+// indexAll returns the indices of all occurrences of sep in b.
+func indexAll(b, sep []byte) (result []int) {
+	for i := 0; ; {
+		j := bytes.Index(b[i:], sep)
+		if j == -1 {
+			return result
+		}
+		result = append(result, i+j)
+		i += j + 1
+	}
+}
+
 // ensureTags set tag values to search for in the stream.
 func (s *TagSplitter) ensureTags() {
 	if len(s.closingTag) == 0 {
@@ -129,12 +213,19 @@ func (s *TagSplitter) Split(data []byte, atEOF bool) (advance int, token []byte,
 	s.buf = append(s.buf, data...)
 	for {
 		if s.batch.Len() >= s.maxBytes() {
-			// Return token, if we hit batch threshold.
+			// Return token, if we hit batch threshold. Data after the last
+			// element is not consumed, but passed again with the next call,
+			// so every token advances the input; this way the scanner does
+			// not give up on many tokens remaining at the end of the input.
+			// The element completing the batch always ends in data, as all
+			// elements before data had been batched in previous calls.
 			b := s.batch.Bytes()
 			s.batch.Reset()
-			return len(data), b, nil
+			advance := len(data) - len(s.buf)
+			s.buf = s.buf[:0]
+			return advance, b, nil
 		}
-		n, err := s.copyContent(&s.batch)
+		n, err := s.copyContent(&s.batch, s.batch.Len() > 0)
 		switch {
 		case err == errOpenTagNotFound:
 			// Keep the internal buffer from growing, but only if we do not
@@ -160,23 +251,32 @@ func (s *TagSplitter) Split(data []byte, atEOF bool) (advance int, token []byte,
 }
 
 // copyContent reads at most one element content from the internal buffer and
-// writes it to the given writer. Returns the number of bytes read, e.g. zero
+// writes it to the given writer, preceded by Separator, if sep is true.
+// Returns the number of bytes read, e.g. zero
 // if no complete element has been found in the internal buffer. This may fail
 // on invalid XML or very large XML elements.
-func (s *TagSplitter) copyContent(w io.Writer) (n int, err error) {
+func (s *TagSplitter) copyContent(w io.Writer, sep bool) (n int, err error) {
 	if len(s.buf) > maxBufSize {
 		return 0, ErrMaxBufSizeExceeded
 	}
-	index := suffixarray.New(s.buf)
+	lookup := s.lookup()
+	// Processing instructions and DOCTYPE declarations may contain text
+	// looking like our tags, which we ignore.
+	regions := markupRegions(s.buf)
 	// We can treat both tags the same, as they have the same length,
 	// accidentally.
-	ot1 := index.Lookup(s.openingTag1, -1)
-	ot2 := index.Lookup(s.openingTag2, -1)
-	openingTagIndices := append(ot1, ot2...)
+	ot1 := lookup(s.openingTag1)
+	ot2 := lookup(s.openingTag2)
+	openingTagIndices := outsideRegions(append(ot1, ot2...), regions)
 	if len(openingTagIndices) == 0 {
+		if len(regions) > 0 && regions[len(regions)-1][1] == len(s.buf) {
+			// Unterminated region, do not prune the buffer, so we can find
+			// its end later.
+			return 0, nil
+		}
 		return 0, errOpenTagNotFound
 	}
-	closingTagIndices := index.Lookup(s.closingTag, -1)
+	closingTagIndices := outsideRegions(lookup(s.closingTag), regions)
 	if len(closingTagIndices) == 0 {
 		return 0, nil
 	}
@@ -201,11 +301,51 @@ func (s *TagSplitter) copyContent(w io.Writer) (n int, err error) {
 		}
 		last = end + len(s.Tag) + 3 // TODO: assumes </...>
 	}
+	if s.StrictBetweenElements && s.seen && (s.noise || skipSpace(s.buf[:start]) < start) {
+		return 0, ErrNoiseBetweenElements
+	}
+	s.seen = true
+	if s.OpenTagFilter != nil {
+		openTag := s.buf[start:]
+		if i := bytes.IndexByte(openTag, '>'); i >= 0 {
+			openTag = openTag[:i+1]
+		}
+		if !s.OpenTagFilter(openTag) {
+			// Skip the element, but report it as read.
+			n = last - start
+			s.buf = s.buf[last:]
+			return n, nil
+		}
+	}
+	if sep && len(s.Separator) > 0 {
+		if _, err = w.Write(s.Separator); err != nil {
+			return 0, err
+		}
+	}
 	n, err = w.Write(s.buf[start:last])
 	s.buf = s.buf[last:] // TODO: optimize this, ringbuffer?
+	s.observe(n)
 	return
 }
 
+// observe counts an element of size n and logs a warning, once the average
+// element is much larger than MaxBytesApprox.
+func (s *TagSplitter) observe(n int) {
+	if s.Logger == nil || s.warned || s.MaxBytesApprox == 0 {
+		return
+	}
+	s.elements++
+	s.elementBytes += n
+	if s.elements < smallBatchWarnElements {
+		return
+	}
+	if avg := s.elementBytes / s.elements; avg >= smallBatchWarnFactor*int(s.MaxBytesApprox) {
+		s.Logger.Printf("record: average element size of %d bytes is much larger than MaxBytesApprox of %d, "+
+			"so every element is a batch of its own, consider a larger MaxBytesApprox", avg, s.MaxBytesApprox)
+	}
+	s.warned = true
+}
+
 // findMatchingTags returns the indices of matching opening and close tags. The
 // opening tag used is always the first one. Returns [-1, -1] if no matching
 // closing tag exists.
@@ -230,3 +370,83 @@ func findMatchingTags(opening []int, closing []int) (int, int) {
 		}
 	}
 }
+
+// markupRegions returns the start and end offsets of processing instructions
+// and DOCTYPE declarations, including internal subsets, found in b. An
+// unterminated region extends to the end of b.
+func markupRegions(b []byte) (regions [][2]int) {
+	i := 0
+	for {
+		j := bytes.IndexByte(b[i:], '<')
+		if j == -1 {
+			return regions
+		}
+		start := i + j
+		switch {
+		case bytes.HasPrefix(b[start:], []byte("<?")):
+			k := bytes.Index(b[start+2:], []byte("?>"))
+			if k == -1 {
+				return append(regions, [2]int{start, len(b)})
+			}
+			i = start + 2 + k + 2
+		case bytes.HasPrefix(b[start:], []byte("<!DOCTYPE")):
+			i = doctypeEnd(b, start)
+			if i == -1 {
+				return append(regions, [2]int{start, len(b)})
+			}
+		default:
+			i = start + 1
+			continue
+		}
+		regions = append(regions, [2]int{start, i})
+	}
+}
+
+// doctypeEnd returns the offset just after the DOCTYPE declaration starting
+// at start, or -1 if the declaration is not terminated in b. Brackets of an
+// internal subset and quoted strings are skipped.
+func doctypeEnd(b []byte, start int) int {
+	var (
+		depth int
+		quote byte
+	)
+	for k := start + 1; k < len(b); k++ {
+		c := b[k]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == '>' && depth <= 0:
+			return k + 1
+		}
+	}
+	return -1
+}
+
+// outsideRegions returns the indices, which are not contained in any region.
+func outsideRegions(indices []int, regions [][2]int) []int {
+	if len(regions) == 0 {
+		return indices
+	}
+	var result []int
+	for _, v := range indices {
+		inside := false
+		for _, r := range regions {
+			if v >= r[0] && v < r[1] {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -2,9 +2,12 @@ package record
 
 import (
 	"bufio"
+	"bytes"
+	"fmt"
 	"reflect"
 	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestSplit(t *testing.T) {
@@ -57,6 +60,27 @@ func TestSplit(t *testing.T) {
 			expectedResultBatches: []string{"<a>1</a>", "<a>2</a>", "<a>3</a>"},
 			err:                   nil,
 		},
+		{
+			doc:                   "empty element, small batch size",
+			tagSplitter:           &TagSplitter{Tag: "a", MaxBytesApprox: 1},
+			input:                 "<a></a><a>x</a>",
+			expectedResultBatches: []string{"<a></a>", "<a>x</a>"},
+			err:                   nil,
+		},
+		{
+			doc:                   "empty elements interleaved, small batch size",
+			tagSplitter:           &TagSplitter{Tag: "a", MaxBytesApprox: 1},
+			input:                 "<a>x</a><a></a><a>y</a><a></a>",
+			expectedResultBatches: []string{"<a>x</a>", "<a></a>", "<a>y</a>", "<a></a>"},
+			err:                   nil,
+		},
+		{
+			doc:                   "empty element with attributes, small batch size",
+			tagSplitter:           &TagSplitter{Tag: "a", MaxBytesApprox: 1},
+			input:                 `<a k="v"></a><a>x</a>`,
+			expectedResultBatches: []string{`<a k="v"></a>`, "<a>x</a>"},
+			err:                   nil,
+		},
 		{
 			doc:                   "two elements, plus noise",
 			tagSplitter:           &TagSplitter{Tag: "a"},
@@ -64,6 +88,34 @@ func TestSplit(t *testing.T) {
 			expectedResultBatches: []string{"<a>1</a><a>2</a>"},
 			err:                   nil,
 		},
+		{
+			doc:                   "strict, whitespace between elements",
+			tagSplitter:           &TagSplitter{Tag: "a", StrictBetweenElements: true},
+			input:                 "<r>\n<a>1</a>\n\t<a>2</a>\n</r>",
+			expectedResultBatches: []string{"<a>1</a><a>2</a>"},
+			err:                   nil,
+		},
+		{
+			doc:                   "strict, noise between elements",
+			tagSplitter:           &TagSplitter{Tag: "a", MaxBytesApprox: 1, StrictBetweenElements: true},
+			input:                 "<a>1</a> HELLO! <a>2</a>",
+			expectedResultBatches: []string{"<a>1</a>"},
+			err:                   ErrNoiseBetweenElements,
+		},
+		{
+			doc:                   "strict, pruned noise between elements",
+			tagSplitter:           &TagSplitter{Tag: "a", StrictBetweenElements: true},
+			input:                 "<a>1</a>" + strings.Repeat("x", 100000) + "<a>2</a>",
+			expectedResultBatches: nil,
+			err:                   ErrNoiseBetweenElements,
+		},
+		{
+			doc:                   "strict, other element between elements",
+			tagSplitter:           &TagSplitter{Tag: "a", StrictBetweenElements: true},
+			input:                 "<a>1</a><b></b><a>2</a>",
+			expectedResultBatches: nil,
+			err:                   ErrNoiseBetweenElements,
+		},
 		{
 			doc:                   "prefix matches",
 			tagSplitter:           &TagSplitter{Tag: "a"},
@@ -113,6 +165,52 @@ func TestSplit(t *testing.T) {
 			expectedResultBatches: []string{`<a>..</a><a>..</a><a>..</a><a>..</a><a>..</a><a>..</a>`},
 			err:                   nil,
 		},
+		{
+			doc:                   "doctype with lookalike tag",
+			tagSplitter:           &TagSplitter{Tag: "a"},
+			input:                 `<?xml version="1.0"?><!DOCTYPE r [<!ELEMENT a (#PCDATA)><!ENTITY e "<a>x</a>">]><r><a>1</a></r>`,
+			expectedResultBatches: []string{`<a>1</a>`},
+			err:                   nil,
+		},
+		{
+			doc:                   "processing instruction with lookalike tag",
+			tagSplitter:           &TagSplitter{Tag: "a", MaxBytesApprox: 1},
+			input:                 `<?pi <a> ?><a>1</a><?pi </a>?><a>2</a>`,
+			expectedResultBatches: []string{`<a>1</a>`, `<a>2</a>`},
+			err:                   nil,
+		},
+		{
+			doc:                   "separator between elements",
+			tagSplitter:           &TagSplitter{Tag: "a", Separator: []byte("\n")},
+			input:                 "<a>1</a>  <a>2</a>\n",
+			expectedResultBatches: []string{"<a>1</a>\n<a>2</a>"},
+			err:                   nil,
+		},
+		{
+			doc:                   "separator, small batch size",
+			tagSplitter:           &TagSplitter{Tag: "a", MaxBytesApprox: 1, Separator: []byte("\n")},
+			input:                 "<a>1</a><a>2</a>",
+			expectedResultBatches: []string{"<a>1</a>", "<a>2</a>"},
+			err:                   nil,
+		},
+		{
+			doc: "open tag filter",
+			tagSplitter: &TagSplitter{Tag: "record", OpenTagFilter: func(b []byte) bool {
+				return bytes.Contains(b, []byte(`type="article"`))
+			}},
+			input:                 `<record type="book">1</record><record type="article">2</record><record>3</record><record type="article">4</record>`,
+			expectedResultBatches: []string{`<record type="article">2</record><record type="article">4</record>`},
+			err:                   nil,
+		},
+		{
+			doc: "open tag filter, nothing matches",
+			tagSplitter: &TagSplitter{Tag: "record", OpenTagFilter: func(b []byte) bool {
+				return false
+			}},
+			input:                 `<record type="book">1</record><record>3</record>`,
+			expectedResultBatches: nil,
+			err:                   nil,
+		},
 	}
 	for _, c := range cases {
 		s := bufio.NewScanner(strings.NewReader(c.input))
@@ -156,3 +254,163 @@ func BenchmarkTagSplitter(b *testing.B) {
 		}
 	}
 }
+
+func TestTagSplitterLookup(t *testing.T) {
+	var many bytes.Buffer
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&many, "<a id=\"%d\"><b>%d</b></a>\n<c/>", i, i)
+	}
+	var cases = []struct {
+		doc   string
+		input string
+		tag   string
+	}{
+		{doc: "empty input", input: "", tag: "a"},
+		{doc: "two elements", input: "<a>1</a><a>2</a>", tag: "a"},
+		{doc: "attributes and noise", input: `<x><a k="v">1</a><b></b><a>3</a></x>`, tag: "a"},
+		{doc: "nested other tags", input: "<a><ab>1</ab><a-b/></a>", tag: "a"},
+		{doc: "processing instruction", input: `<?x <a>?><a>1</a>`, tag: "a"},
+		{doc: "garbled", input: "</a><a>1</a>", tag: "a"},
+		{doc: "many elements", input: many.String(), tag: "a"},
+	}
+	split := func(input, tag string, minSize int) ([]string, error) {
+		ts := &TagSplitter{Tag: tag, MaxBytesApprox: 1000, SuffixArrayMinSize: minSize}
+		s := bufio.NewScanner(strings.NewReader(input))
+		s.Split(ts.Split)
+		var result []string
+		for s.Scan() {
+			result = append(result, s.Text())
+		}
+		return result, s.Err()
+	}
+	for _, c := range cases {
+		want, wantErr := split(c.input, c.tag, 1)
+		got, err := split(c.input, c.tag, maxBufSize)
+		if err != wantErr {
+			t.Fatalf("[%s] got %v, want %v", c.doc, err, wantErr)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("[%s] got (%d) %v, want (%d) %v", c.doc, len(got), got, len(want), want)
+		}
+	}
+}
+
+func BenchmarkTagSplitterLookup(b *testing.B) {
+	// Elements of about size bytes, either one large element with many
+	// children, or many small elements with the tag to split on.
+	shapes := map[string]func(size int) []byte{
+		"large": func(size int) []byte {
+			var buf bytes.Buffer
+			buf.WriteString("<a>")
+			for buf.Len() < size {
+				buf.WriteString("<b>x</b>")
+			}
+			buf.WriteString("</a>")
+			return buf.Bytes()
+		},
+		"many": func(size int) []byte {
+			return bytes.Repeat([]byte("<a>x</a>"), size/8)
+		},
+	}
+	for _, shape := range []string{"large", "many"} {
+		for _, size := range []int{1 << 10, 1 << 14, 1 << 17} {
+			data := shapes[shape](size)
+			for _, mode := range []struct {
+				name    string
+				minSize int
+			}{
+				{"index", maxBufSize},
+				{"suffixarray", 1},
+			} {
+				b.Run(fmt.Sprintf("%s-%s-%d", shape, mode.name, size), func(b *testing.B) {
+					for n := 0; n < b.N; n++ {
+						ts := TagSplitter{Tag: "a", SuffixArrayMinSize: mode.minSize}
+						s := bufio.NewScanner(bytes.NewReader(data))
+						s.Buffer(make([]byte, 4096), maxBufSize)
+						s.Split(ts.Split)
+						for s.Scan() {
+						}
+						if s.Err() != nil {
+							b.Fatal(s.Err())
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+// messageLogger collects logged messages.
+type messageLogger struct {
+	messages []string
+}
+
+func (l *messageLogger) Printf(format string, args ...any) {
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+}
+
+func TestTagSplitterSmallBatchWarning(t *testing.T) {
+	element := "<a>" + strings.Repeat("x", 100) + "</a>\n"
+	var cases = []struct {
+		doc            string
+		maxBytesApprox uint
+		elements       int
+		warnings       int
+	}{
+		{doc: "tiny batch size, large elements", maxBytesApprox: 1, elements: 200, warnings: 1},
+		{doc: "tiny batch size, few elements", maxBytesApprox: 1, elements: 50, warnings: 0},
+		{doc: "batch size fits elements", maxBytesApprox: 1000, elements: 200, warnings: 0},
+		{doc: "default batch size", maxBytesApprox: 0, elements: 200, warnings: 0},
+	}
+	for _, c := range cases {
+		var (
+			logger = &messageLogger{}
+			ts     = &TagSplitter{Tag: "a", MaxBytesApprox: c.maxBytesApprox, Logger: logger}
+			// Reading a byte at a time passes at most one complete element
+			// to each call of Split.
+			s = bufio.NewScanner(iotest.OneByteReader(strings.NewReader(strings.Repeat(element, c.elements))))
+		)
+		s.Split(ts.Split)
+		for s.Scan() {
+		}
+		if s.Err() != nil {
+			t.Fatalf("[%s] got %v, want nil", c.doc, s.Err())
+		}
+		if len(logger.messages) != c.warnings {
+			t.Errorf("[%s] got %d warnings %v, want %d", c.doc, len(logger.messages), logger.messages, c.warnings)
+		}
+	}
+}
+
+func TestTagSplitterManySmallBatches(t *testing.T) {
+	// With a tiny MaxBytesApprox, every element is a batch. A single read
+	// holds many elements, so the batches must not consume all of the data,
+	// or the scanner panics on too many tokens without progress.
+	var cases = []struct {
+		doc      string
+		elements int
+	}{
+		{doc: "few elements", elements: 10},
+		{doc: "more elements than the scanner allows empty tokens", elements: 1000},
+	}
+	for _, c := range cases {
+		var (
+			ts = &TagSplitter{Tag: "a", MaxBytesApprox: 1}
+			s  = bufio.NewScanner(strings.NewReader(strings.Repeat("<a>1</a>", c.elements)))
+			n  int
+		)
+		s.Split(ts.Split)
+		for s.Scan() {
+			if got := s.Text(); got != "<a>1</a>" {
+				t.Fatalf("[%s] got %q, want %q", c.doc, got, "<a>1</a>")
+			}
+			n++
+		}
+		if s.Err() != nil {
+			t.Fatalf("[%s] got %v, want nil", c.doc, s.Err())
+		}
+		if n != c.elements {
+			t.Errorf("[%s] got %d batches, want %d", c.doc, n, c.elements)
+		}
+	}
+}
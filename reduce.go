@@ -0,0 +1,38 @@
+package parallel
+
+import "errors"
+
+// ErrReduceOutput is returned by Reduce, if an option is set, which shapes
+// the output written to W, like SummaryFunc, CompressBatches or
+// LengthPrefixSize, as the reducer would receive summaries, compressed
+// blocks or frames instead of results.
+var ErrReduceOutput = errors.New("reduce does not support SummaryFunc, CompressBatches or LengthPrefixSize")
+
+// reduceSink folds results into an accumulator.
+type reduceSink[R any] struct {
+	acc     R
+	reducer func(R, []byte) R
+}
+
+func (s *reduceSink[R]) Write(b []byte) (int, error) {
+	if len(b) > 0 {
+		s.acc = s.reducer(s.acc, b)
+	}
+	return len(b), nil
+}
+
+func (s *reduceSink[R]) Flush() error { return nil }
+
+// Reduce runs the processor, but instead of writing results to p.W, it folds
+// each non-empty result into an accumulator, starting with initial. The
+// reducer is called serially from a single goroutine, so it does not need any
+// synchronisation. This allows aggregations like sums, counts or histograms
+// on top of a parallel map.
+func Reduce[R any](p *Processor, initial R, reducer func(R, []byte) R) (R, error) {
+	if p.SummaryFunc != nil || p.CompressBatches || p.LengthPrefixSize > 0 {
+		return initial, ErrReduceOutput
+	}
+	s := &reduceSink[R]{acc: initial, reducer: reducer}
+	_, err := p.run(s)
+	return s.acc, err
+}
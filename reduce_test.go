@@ -0,0 +1,57 @@
+package parallel
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReduce(t *testing.T) {
+	var input strings.Builder
+	for i := 1; i <= 1000; i++ {
+		fmt.Fprintf(&input, "%d\n", i)
+	}
+	p := NewProcessor(strings.NewReader(input.String()), nil, func(b []byte) ([]byte, error) {
+		// Drop odd numbers, double even ones.
+		v, err := strconv.Atoi(string(bytes.TrimSpace(b)))
+		if err != nil {
+			return nil, err
+		}
+		if v%2 == 1 {
+			return nil, nil
+		}
+		return []byte(strconv.Itoa(2 * v)), nil
+	})
+	p.BatchSize = 7
+	sum, err := Reduce(p, 0, func(acc int, b []byte) int {
+		v, _ := strconv.Atoi(string(b))
+		return acc + v
+	})
+	if err != nil {
+		t.Fatalf("Reduce: got %v, want nil", err)
+	}
+	if want := 2 * 250500; sum != want {
+		t.Fatalf("Reduce: got %d, want %d", sum, want)
+	}
+}
+
+func TestReduceUnsupported(t *testing.T) {
+	var cases = []struct {
+		about string
+		f     func(p *Processor)
+	}{
+		{about: `SummaryFunc.`, f: func(p *Processor) { p.SummaryFunc = func(Stats) []byte { return nil } }},
+		{about: `CompressBatches.`, f: func(p *Processor) { p.CompressBatches = true }},
+		{about: `LengthPrefixSize.`, f: func(p *Processor) { p.LengthPrefixSize = 4 }},
+	}
+	for _, c := range cases {
+		p := NewProcessor(strings.NewReader("1\n2\n"), nil, ToTransformerFunc(bytes.TrimSpace))
+		c.f(p)
+		n, err := Reduce(p, 0, func(acc int, b []byte) int { return acc + 1 })
+		if err != ErrReduceOutput || n != 0 {
+			t.Errorf("[%s] Reduce: got %d, %v, want 0, %v", c.about, n, err, ErrReduceOutput)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package parallel
+
+// batchResult holds the results of a batch, with the batch number.
+type batchResult struct {
+	seq int64
+	rs  []result
+}
+
+// reorder passes the results of batches to out in batch order, buffering at
+// most window batches, see ReorderWindow. Batches arriving after they have
+// been given up on are passed on immediately.
+func reorder(in chan batchResult, out chan result, window int) {
+	var (
+		next    int64
+		pending = make(map[int64][]result)
+	)
+	emit := func(rs []result) {
+		for _, r := range rs {
+			out <- r
+		}
+	}
+	// advance emits all consecutive batches starting at next.
+	advance := func() {
+		for {
+			rs, ok := pending[next]
+			if !ok {
+				return
+			}
+			delete(pending, next)
+			emit(rs)
+			next++
+		}
+	}
+	for b := range in {
+		if b.seq < next {
+			emit(b.rs)
+			continue
+		}
+		pending[b.seq] = b.rs
+		advance()
+		for len(pending) > window {
+			// Give up on the missing batches before the earliest one.
+			next = lowest(pending)
+			advance()
+		}
+	}
+	for len(pending) > 0 {
+		next = lowest(pending)
+		advance()
+	}
+}
+
+// lowest returns the smallest batch number in m, which must not be empty.
+func lowest(m map[int64][]result) int64 {
+	var (
+		low   int64
+		found bool
+	)
+	for k := range m {
+		if !found || k < low {
+			low, found = k, true
+		}
+	}
+	return low
+}
@@ -0,0 +1,123 @@
+package parallel
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReorder(t *testing.T) {
+	var cases = []struct {
+		about    string
+		window   int
+		arrivals []int64
+		expected []int64
+	}{
+		{
+			about:    `In order.`,
+			window:   2,
+			arrivals: []int64{0, 1, 2, 3},
+			expected: []int64{0, 1, 2, 3},
+		},
+		{
+			about:    `Reordered within window.`,
+			window:   2,
+			arrivals: []int64{1, 2, 0, 4, 3},
+			expected: []int64{0, 1, 2, 3, 4},
+		},
+		{
+			about:    `Late batch beyond window.`,
+			window:   2,
+			arrivals: []int64{1, 2, 3, 4, 0, 5},
+			expected: []int64{1, 2, 3, 4, 0, 5},
+		},
+		{
+			about:    `Gap in the middle.`,
+			window:   1,
+			arrivals: []int64{0, 2, 3, 1, 4},
+			expected: []int64{0, 2, 3, 1, 4},
+		},
+		{
+			about:    `Remaining batches at the end.`,
+			window:   4,
+			arrivals: []int64{3, 1, 2},
+			expected: []int64{1, 2, 3},
+		},
+	}
+	for _, c := range cases {
+		var (
+			in  = make(chan batchResult)
+			out = make(chan result)
+		)
+		go func() {
+			reorder(in, out, c.window)
+			close(out)
+		}()
+		go func() {
+			for _, seq := range c.arrivals {
+				in <- batchResult{seq: seq, rs: []result{{b: []byte(strconv.FormatInt(seq, 10))}}}
+			}
+			close(in)
+		}()
+		var result []int64
+		for r := range out {
+			v, _ := strconv.ParseInt(string(r.b), 10, 64)
+			result = append(result, v)
+		}
+		if !reflect.DeepEqual(result, c.expected) {
+			t.Errorf("[%s] got %v, want %v", c.about, result, c.expected)
+		}
+	}
+}
+
+func TestReorderWindow(t *testing.T) {
+	var (
+		input    strings.Builder
+		expected []string
+	)
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&input, "%d\n", i)
+		expected = append(expected, strconv.Itoa(i))
+	}
+	// Within the window, output follows input order.
+	var buf bytes.Buffer
+	p := NewProcessor(strings.NewReader(input.String()), &buf, func(b []byte) ([]byte, error) {
+		v, _ := strconv.Atoi(string(bytes.TrimSpace(b)))
+		time.Sleep(time.Duration(v%7) * 100 * time.Microsecond)
+		return b, nil
+	})
+	p.BatchSize = 1
+	p.NumWorkers = 8
+	p.ReorderWindow = 1000
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if result := strings.Fields(buf.String()); !reflect.DeepEqual(result, expected) {
+		t.Errorf("got %v, want %v", result, expected)
+	}
+	// A stalled record beyond the window does not hold back the output.
+	buf.Reset()
+	p = NewProcessor(strings.NewReader(input.String()), &buf, func(b []byte) ([]byte, error) {
+		if string(b) == "0\n" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		return b, nil
+	})
+	p.BatchSize = 1
+	p.NumWorkers = 4
+	p.ReorderWindow = 8
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	result := strings.Fields(buf.String())
+	if len(result) != len(expected) {
+		t.Fatalf("got %d records, want %d", len(result), len(expected))
+	}
+	if result[0] == "0" {
+		t.Errorf("got stalled record first, want it written late")
+	}
+}
@@ -0,0 +1,88 @@
+package parallel
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// router writes routed results to their writers, and all other results to
+// the sink. Routed writers are buffered and created on first use.
+type router struct {
+	sink
+	p       *Processor
+	writers map[string]*bufio.Writer
+	// closers are the writers created by RouteWriter, which need closing.
+	closers []io.Closer
+}
+
+// writer returns the buffered writer for route, or nil, if there is no
+// writer for route.
+func (r *router) writer(route string) (*bufio.Writer, error) {
+	if bw, ok := r.writers[route]; ok {
+		return bw, nil
+	}
+	w, ok := r.p.Routes[route]
+	if !ok && r.p.RouteWriter != nil {
+		var err error
+		if w, err = r.p.RouteWriter(route); err != nil {
+			return nil, err
+		}
+		if c, ok := w.(io.Closer); ok {
+			r.closers = append(r.closers, c)
+		}
+	}
+	var bw *bufio.Writer
+	if w != nil {
+		bw = bufio.NewWriterSize(w, r.p.WriteBufferSize)
+	}
+	if r.writers == nil {
+		r.writers = make(map[string]*bufio.Writer)
+	}
+	r.writers[route] = bw
+	return bw, nil
+}
+
+// writeRoute writes b to the writer for route, or to the sink.
+func (r *router) writeRoute(route string, b []byte) (int, error) {
+	if route == "" {
+		return r.sink.Write(b)
+	}
+	bw, err := r.writer(route)
+	if err != nil {
+		return 0, err
+	}
+	if bw == nil {
+		return r.sink.Write(b)
+	}
+	return bw.Write(b)
+}
+
+// Flush flushes all routed writers and the sink.
+func (r *router) Flush() error {
+	var errs []error
+	for _, bw := range r.writers {
+		if bw == nil {
+			continue
+		}
+		if err := bw.Flush(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := r.sink.Flush(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// close closes all writers created by RouteWriter, which implement io.Closer.
+// Call Flush first.
+func (r *router) close() error {
+	var errs []error
+	for _, c := range r.closers {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
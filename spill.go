@@ -0,0 +1,76 @@
+package parallel
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+)
+
+// SpillSink lets each worker write its results to its own temporary file,
+// bypassing the single writer goroutine. At the end of a run, the files are
+// appended to W in worker order and removed. This trades an extra pass over
+// the output for removing contention on the writer, the output order is not
+// preserved.
+type SpillSink struct {
+	// Dir is the directory for the temporary files, defaults to
+	// os.TempDir.
+	Dir string
+}
+
+// spillFile is the temporary file of a worker.
+type spillFile struct {
+	f  *os.File
+	bw *bufio.Writer
+	// n is the number of non-empty results written.
+	n int64
+}
+
+// create creates a temporary file for each of n workers.
+func (s *SpillSink) create(n int) ([]*spillFile, error) {
+	var files []*spillFile
+	for i := 0; i < n; i++ {
+		f, err := os.CreateTemp(s.Dir, "parallel-spill-*")
+		if err != nil {
+			return nil, errors.Join(err, removeSpillFiles(files))
+		}
+		files = append(files, &spillFile{f: f, bw: bufio.NewWriter(f)})
+	}
+	return files, nil
+}
+
+// write writes a result to the file.
+func (f *spillFile) write(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	f.n++
+	_, err := f.bw.Write(b)
+	return err
+}
+
+// copyTo writes the contents of the file to w.
+func (f *spillFile) copyTo(w io.Writer) error {
+	if err := f.bw.Flush(); err != nil {
+		return err
+	}
+	if _, err := f.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.Copy(w, f.f)
+	return err
+}
+
+// removeSpillFiles closes and removes all files.
+func removeSpillFiles(files []*spillFile) error {
+	var errs []error
+	for _, f := range files {
+		if err := f.f.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		if err := os.Remove(f.f.Name()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,60 @@
+package parallel
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSpillSink(t *testing.T) {
+	var (
+		input    strings.Builder
+		expected []string
+	)
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&input, "line %d\n", i)
+		expected = append(expected, fmt.Sprintf("LINE %d", i))
+	}
+	sort.Strings(expected)
+	var cases = []struct {
+		about      string
+		numWorkers int
+		batchSize  int
+	}{
+		{about: `Single worker.`, numWorkers: 1, batchSize: 100},
+		{about: `Many workers, small batches.`, numWorkers: 8, batchSize: 3},
+	}
+	for _, c := range cases {
+		var (
+			buf bytes.Buffer
+			dir = t.TempDir()
+		)
+		p := NewProcessor(strings.NewReader(input.String()), &buf, ToTransformerFunc(bytes.ToUpper))
+		p.NumWorkers = c.numWorkers
+		p.BatchSize = c.batchSize
+		p.Spill = &SpillSink{Dir: dir}
+		n, err := p.RunN()
+		if err != nil {
+			t.Fatalf("[%s] p.RunN: got %v, want nil", c.about, err)
+		}
+		if n != 1000 {
+			t.Errorf("[%s] p.RunN: got %d, want 1000", c.about, n)
+		}
+		result := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		sort.Strings(result)
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("[%s] got %d lines, want %d, results differ", c.about, len(result), len(expected))
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("[%s] os.ReadDir: got %v, want nil", c.about, err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("[%s] got %d spill files left, want 0", c.about, len(entries))
+		}
+	}
+}
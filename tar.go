@@ -0,0 +1,68 @@
+package parallel
+
+import (
+	"archive/tar"
+	"io"
+	"runtime"
+)
+
+// TarFunc transforms the content of a single archive entry.
+type TarFunc func(name string, content []byte) ([]byte, error)
+
+// tarEntry is a regular file read from an archive.
+type tarEntry struct {
+	name    string
+	content []byte
+}
+
+// TarProcessor processes the entries of a tar archive in parallel. Only
+// regular files are passed to F, directories and other entry types are
+// skipped. Entries are read one at a time, so besides the entries currently
+// processed by the workers, the archive is not kept in memory.
+type TarProcessor struct {
+	NumWorkers int
+	R          io.Reader
+	W          io.Writer
+	F          TarFunc
+}
+
+// NewTarProcessor creates a new processor for tar archive entries.
+func NewTarProcessor(r io.Reader, w io.Writer, f TarFunc) *TarProcessor {
+	return &TarProcessor{
+		NumWorkers: runtime.NumCPU(),
+		R:          r,
+		W:          w,
+		F:          f,
+	}
+}
+
+// Run reads the archive and dispatches each regular file to a worker.
+func (p *TarProcessor) Run() error {
+	return fanout(p.NumWorkers, p.W, p.entries, func(e tarEntry) ([]byte, error) {
+		return p.F(e.name, e.content)
+	})
+}
+
+// entries reads regular files from the archive.
+func (p *TarProcessor) entries(emit func(tarEntry) bool) error {
+	tr := tar.NewReader(p.R)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		if !emit(tarEntry{name: hdr.Name, content: b}) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package parallel
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestTarProcessor(t *testing.T) {
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	if err := tw.WriteHeader(&tar.Header{Name: "docs/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"docs/a.json": `{"id": 1}`,
+		"docs/b.json": `{"id": 2}`,
+		"docs/c.json": `{"id": 3}`,
+	}
+	for _, name := range []string{"docs/a.json", "docs/b.json", "docs/c.json"} {
+		content := files[name]
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	p := NewTarProcessor(&archive, &buf, func(name string, content []byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s\t%s\n", name, content)), nil
+	})
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	expected := "docs/a.json\t{\"id\": 1}\ndocs/b.json\t{\"id\": 2}\ndocs/c.json\t{\"id\": 3}\n"
+	if !LinesEqual(buf.String(), expected) {
+		t.Errorf("p.Run: got %v, want %v", buf.String(), expected)
+	}
+}
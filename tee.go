@@ -0,0 +1,53 @@
+package parallel
+
+import (
+	"errors"
+	"io"
+)
+
+// teeWriter writes to all writers. A writer is dropped after its first
+// failed write, while the others keep receiving all output.
+type teeWriter struct {
+	ws   []io.Writer
+	errs []error
+}
+
+// Write writes p to all remaining writers. It only fails, once all writers
+// have failed, so that a failing writer does not stop the run.
+func (t *teeWriter) Write(p []byte) (int, error) {
+	ws := t.ws[:0]
+	for _, w := range t.ws {
+		n, err := w.Write(p)
+		if err == nil && n < len(p) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			t.errs = append(t.errs, err)
+			continue
+		}
+		ws = append(ws, w)
+	}
+	t.ws = ws
+	if len(t.ws) == 0 {
+		return 0, t.err()
+	}
+	return len(p), nil
+}
+
+// err returns the errors of all failed writers, joined.
+func (t *teeWriter) err() error {
+	return errors.Join(t.errs...)
+}
+
+// output returns the writer for results, W and any additional Writers.
+func (p *Processor) output() io.Writer {
+	if len(p.Writers) == 0 {
+		return p.W
+	}
+	t := &teeWriter{}
+	if p.W != nil {
+		t.ws = append(t.ws, p.W)
+	}
+	t.ws = append(t.ws, p.Writers...)
+	return t
+}
@@ -0,0 +1,48 @@
+package parallel
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// failingWriter fails every write.
+type failingWriter struct{ err error }
+
+func (w failingWriter) Write(p []byte) (int, error) { return 0, w.err }
+
+func TestWriters(t *testing.T) {
+	var a, b bytes.Buffer
+	p := NewProcessor(strings.NewReader("a\nb\nc\n"), &a, ToTransformerFunc(bytes.ToUpper))
+	p.Writers = []io.Writer{&b}
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if !LinesEqual(a.String(), "A\nB\nC\n") {
+		t.Errorf("p.Run: got %q, want %q", a.String(), "A\nB\nC\n")
+	}
+	if a.String() != b.String() {
+		t.Errorf("p.Run: got %q and %q, want identical output", a.String(), b.String())
+	}
+	// A failing writer is reported, the others still receive all output,
+	// also beyond the first flush of the output buffer.
+	input := strings.Repeat("a\nb\nc\n", 10000)
+	var c bytes.Buffer
+	errFailed := errors.New("failed")
+	p = NewProcessor(strings.NewReader(input), failingWriter{errFailed}, ToTransformerFunc(bytes.ToUpper))
+	p.Writers = []io.Writer{&c}
+	if err := p.Run(); !errors.Is(err, errFailed) {
+		t.Fatalf("p.Run: got %v, want %v", err, errFailed)
+	}
+	if want := strings.ToUpper(input); !LinesEqual(c.String(), want) {
+		t.Errorf("p.Run: got %d bytes, want %d", c.Len(), len(want))
+	}
+	// Once all writers failed, the run stops.
+	p = NewProcessor(strings.NewReader(input), failingWriter{errFailed}, ToTransformerFunc(bytes.ToUpper))
+	p.Writers = []io.Writer{failingWriter{errFailed}}
+	if err := p.Run(); !errors.Is(err, errFailed) {
+		t.Fatalf("p.Run: got %v, want %v", err, errFailed)
+	}
+}
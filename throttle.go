@@ -0,0 +1,48 @@
+package parallel
+
+import (
+	"io"
+	"time"
+)
+
+// throttledReader limits the read rate with a token bucket over bytes. The
+// bucket holds at most one second worth of bytes and starts empty.
+type throttledReader struct {
+	r      io.Reader
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+// Read reads at most rate bytes, then sleeps until the bucket is no longer
+// in debt.
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > t.rate {
+		p = p[:t.rate]
+	}
+	n, err := t.r.Read(p)
+	now := time.Now()
+	if t.last.IsZero() {
+		t.last = now
+	}
+	rate := float64(t.rate)
+	t.tokens = min(t.tokens+now.Sub(t.last).Seconds()*rate, rate) - float64(n)
+	t.last = now
+	if t.tokens < 0 {
+		time.Sleep(time.Duration(-t.tokens / rate * float64(time.Second)))
+	}
+	return n, err
+}
+
+// input returns R, throttled to MaxBytesPerSecond, if set, and decompressed,
+// if requested.
+func (p *Processor) input() io.Reader {
+	r := p.R
+	if p.MaxBytesPerSecond > 0 {
+		r = &throttledReader{r: r, rate: p.MaxBytesPerSecond}
+	}
+	if p.Decompress {
+		r = &decompressReader{r: r}
+	}
+	return r
+}
@@ -0,0 +1,40 @@
+package parallel
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaxBytesPerSecond(t *testing.T) {
+	var cases = []struct {
+		about string
+		size  int
+		rate  int64
+		min   time.Duration
+	}{
+		{about: `No limit.`, size: 50000, rate: 0, min: 0},
+		{about: `Half a second.`, size: 50000, rate: 100000, min: 400 * time.Millisecond},
+		{about: `Small rate.`, size: 100, rate: 400, min: 200 * time.Millisecond},
+	}
+	for _, c := range cases {
+		input := strings.Repeat(strings.Repeat("x", 9)+"\n", c.size/10)
+		var buf bytes.Buffer
+		p := NewProcessor(strings.NewReader(input), &buf, func(b []byte) ([]byte, error) {
+			return b, nil
+		})
+		p.MaxBytesPerSecond = c.rate
+		started := time.Now()
+		if err := p.Run(); err != nil {
+			t.Fatalf("[%s] p.Run: got %v, want nil", c.about, err)
+		}
+		// Only the lower bound is checked, as slow machines may take longer.
+		if elapsed := time.Since(started); elapsed < c.min {
+			t.Errorf("[%s] got %v, want at least %v", c.about, elapsed, c.min)
+		}
+		if buf.Len() != len(input) {
+			t.Errorf("[%s] got %d bytes, want %d", c.about, buf.Len(), len(input))
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package parallel
+
+import (
+	"io"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// TimestampFunc extracts the timestamp of a record.
+type TimestampFunc func([]byte) (time.Time, error)
+
+// TimeWindowFunc aggregates the records of the time window beginning at
+// start.
+type TimeWindowFunc func(start time.Time, records [][]byte) ([]byte, error)
+
+// timeWindow is a time window with its records.
+type timeWindow struct {
+	start   time.Time
+	records [][]byte
+}
+
+// TimeWindowProcessor aggregates records by time windows, based on a
+// timestamp extracted from each record. Windows last Size and start at
+// multiples of Step, so with Step equal to Size (or zero) windows are
+// tumbling, with a smaller Step they are sliding and a record belongs to
+// multiple windows. Each window with at least one record is passed to F in a
+// worker, so the order of the output is not preserved.
+//
+// The input is expected to be roughly ordered by time. A window is complete
+// and dispatched, once a record at least AllowedLateness past the end of the
+// window has been read. Records arriving after all their windows have been
+// dispatched are late and dropped; they are passed to OnLate, if set.
+type TimeWindowProcessor struct {
+	Size            time.Duration
+	Step            time.Duration
+	AllowedLateness time.Duration
+	RecordSeparator byte
+	NumWorkers      int
+	R               io.Reader
+	W               io.Writer
+	TimestampFunc   TimestampFunc
+	F               TimeWindowFunc
+	OnLate          func([]byte)
+}
+
+// NewTimeWindowProcessor creates a new processor with tumbling windows of the
+// given size.
+func NewTimeWindowProcessor(r io.Reader, w io.Writer, size time.Duration, ts TimestampFunc, f TimeWindowFunc) *TimeWindowProcessor {
+	return &TimeWindowProcessor{
+		Size:            size,
+		RecordSeparator: '\n',
+		NumWorkers:      runtime.NumCPU(),
+		R:               r,
+		W:               w,
+		TimestampFunc:   ts,
+		F:               f,
+	}
+}
+
+// step returns the distance between the starts of consecutive windows.
+func (p *TimeWindowProcessor) step() time.Duration {
+	if p.Step == 0 {
+		return p.Size
+	}
+	return p.Step
+}
+
+// Run reads all records and dispatches complete windows to the workers. All
+// remaining windows are dispatched at the end of the input. Records keep
+// their separator. An error from TimestampFunc stops processing.
+func (p *TimeWindowProcessor) Run() error {
+	if p.Size <= 0 || p.Step < 0 || p.AllowedLateness < 0 {
+		return ErrInvalidWindow
+	}
+	return fanout(p.NumWorkers, p.W, p.windows, func(w timeWindow) ([]byte, error) {
+		return p.F(w.start, w.records)
+	})
+}
+
+// windows assigns records to open windows, ordered by start, and emits
+// windows once the largest timestamp seen passes their end.
+func (p *TimeWindowProcessor) windows(emit func(timeWindow) bool) error {
+	var (
+		open      []*timeWindow
+		watermark time.Time
+		tsErr     error
+		step      = p.step()
+	)
+	// closed reports, whether a window beginning at start is complete.
+	closed := func(start time.Time) bool {
+		return !start.Add(p.Size + p.AllowedLateness).After(watermark)
+	}
+	err := readRecords(p.R, p.RecordSeparator, func(b []byte) bool {
+		t, err := p.TimestampFunc(b)
+		if err != nil {
+			tsErr = err
+			return false
+		}
+		if t.After(watermark) {
+			watermark = t
+		}
+		var added, late bool
+		for start := t.Truncate(step); start.Add(p.Size).After(t); start = start.Add(-step) {
+			if closed(start) {
+				late = true
+				continue
+			}
+			added = true
+			i := sort.Search(len(open), func(i int) bool {
+				return !open[i].start.Before(start)
+			})
+			if i == len(open) || !open[i].start.Equal(start) {
+				open = append(open, nil)
+				copy(open[i+1:], open[i:])
+				open[i] = &timeWindow{start: start}
+			}
+			open[i].records = append(open[i].records, b)
+		}
+		if late && !added && p.OnLate != nil {
+			p.OnLate(b)
+		}
+		for len(open) > 0 && closed(open[0].start) {
+			w := open[0]
+			open = open[1:]
+			if !emit(*w) {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if tsErr != nil {
+		return tsErr
+	}
+	for _, w := range open {
+		if !emit(*w) {
+			break
+		}
+	}
+	return nil
+}
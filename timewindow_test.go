@@ -0,0 +1,117 @@
+package parallel
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseTimestamp reads a unix timestamp from the first field of a record.
+func parseTimestamp(b []byte) (time.Time, error) {
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return time.Time{}, errors.New("missing timestamp")
+	}
+	v, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(v, 0).UTC(), nil
+}
+
+// sumWindow sums the second field of all records in a window.
+func sumWindow(start time.Time, records [][]byte) ([]byte, error) {
+	var sum int
+	for _, b := range records {
+		v, err := strconv.Atoi(strings.Fields(string(b))[1])
+		if err != nil {
+			return nil, err
+		}
+		sum += v
+	}
+	return []byte(fmt.Sprintf("%d %d\n", start.Unix(), sum)), nil
+}
+
+func TestTimeWindowProcessor(t *testing.T) {
+	var cases = []struct {
+		about    string
+		input    string
+		size     time.Duration
+		step     time.Duration
+		lateness time.Duration
+		expected []string
+		late     []string
+		err      error
+	}{
+		{
+			about:    `Tumbling windows.`,
+			input:    "0 1\n30 2\n59 3\n60 4\n119 5\n",
+			size:     time.Minute,
+			expected: []string{"0 6", "60 9"},
+		},
+		{
+			about:    `Sliding windows.`,
+			input:    "0 1\n30 2\n60 4\n",
+			size:     time.Minute,
+			step:     30 * time.Second,
+			expected: []string{"-30 1", "0 3", "30 6", "60 4"},
+		},
+		{
+			about:    `Late record is dropped.`,
+			input:    "0 1\n60 2\n30 4\n",
+			size:     time.Minute,
+			expected: []string{"0 1", "60 2"},
+			late:     []string{"30 4\n"},
+		},
+		{
+			about:    `Late record within allowed lateness.`,
+			input:    "0 1\n60 2\n30 4\n",
+			size:     time.Minute,
+			lateness: time.Minute,
+			expected: []string{"0 5", "60 2"},
+		},
+		{
+			about: `Invalid timestamp.`,
+			input: "0 1\nx 2\n",
+			size:  time.Minute,
+			err:   strconv.ErrSyntax,
+		},
+		{
+			about: `Invalid window.`,
+			input: "0 1\n",
+			err:   ErrInvalidWindow,
+		},
+	}
+	for _, c := range cases {
+		var (
+			buf  bytes.Buffer
+			late []string
+		)
+		p := NewTimeWindowProcessor(strings.NewReader(c.input), &buf, c.size, parseTimestamp, sumWindow)
+		p.Step = c.step
+		p.AllowedLateness = c.lateness
+		p.OnLate = func(b []byte) {
+			late = append(late, string(b))
+		}
+		if err := p.Run(); !errors.Is(err, c.err) {
+			t.Fatalf("[%s] got %v, want %v", c.about, err, c.err)
+		}
+		if c.err != nil {
+			continue
+		}
+		result := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		sort.Strings(result)
+		if !reflect.DeepEqual(result, c.expected) {
+			t.Errorf("[%s] got %v, want %v", c.about, result, c.expected)
+		}
+		if !reflect.DeepEqual(late, c.late) {
+			t.Errorf("[%s] late: got %v, want %v", c.about, late, c.late)
+		}
+	}
+}
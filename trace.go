@@ -0,0 +1,24 @@
+package parallel
+
+import "time"
+
+// Trace records the processing of a single record.
+type Trace struct {
+	// Offset is the input offset of the record.
+	Offset int64
+	// Worker is the id of the worker, that processed the record.
+	Worker int
+	// Read is the time the record was read, Start and End the times the
+	// transformer was called and returned. The time between Read and Start
+	// is spent waiting for the batch to fill and for a free worker.
+	Read  time.Time
+	Start time.Time
+	End   time.Time
+	// Err is the error returned by the transformer.
+	Err error
+}
+
+// Duration returns the time spent in the transformer.
+func (t Trace) Duration() time.Duration {
+	return t.End.Sub(t.Start)
+}
@@ -0,0 +1,55 @@
+package parallel
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTraceFunc(t *testing.T) {
+	var input bytes.Buffer
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&input, "%d\n", i)
+	}
+	var (
+		mu     sync.Mutex
+		traces []Trace
+	)
+	p := NewProcessor(&input, &bytes.Buffer{}, func(b []byte) ([]byte, error) {
+		time.Sleep(100 * time.Microsecond)
+		return b, nil
+	})
+	p.BatchSize = 10
+	p.NumWorkers = 4
+	p.TraceFunc = func(t Trace) {
+		mu.Lock()
+		defer mu.Unlock()
+		traces = append(traces, t)
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if len(traces) != 100 {
+		t.Fatalf("got %d traces, want 100", len(traces))
+	}
+	sort.Slice(traces, func(i, j int) bool { return traces[i].Offset < traces[j].Offset })
+	var offset int64
+	for i, tr := range traces {
+		if tr.Offset != offset {
+			t.Errorf("trace %d: got offset %d, want %d", i, tr.Offset, offset)
+		}
+		offset += int64(len(fmt.Sprintf("%d\n", i)))
+		if tr.Read.After(tr.Start) || tr.Start.After(tr.End) {
+			t.Errorf("trace %d: got read %v, start %v, end %v, want ordered", i, tr.Read, tr.Start, tr.End)
+		}
+		if tr.Duration() < 100*time.Microsecond {
+			t.Errorf("trace %d: got duration %v, want at least 100µs", i, tr.Duration())
+		}
+		if tr.Worker < 0 || tr.Worker >= p.NumWorkers {
+			t.Errorf("trace %d: got worker %d, want between 0 and %d", i, tr.Worker, p.NumWorkers-1)
+		}
+	}
+}
@@ -4,22 +4,60 @@ package parallel
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"runtime"
 	"sync"
+	"sync/atomic"
 )
 
 const defaultBatchSize = 16777216
 
-// Func is a generic processing function.
+// MaxWorkers is the maximum number of workers a Proc accepts.
+const MaxWorkers = 65536
+
+// ErrInvalidWorkers is returned, if the number of workers is not between one
+// and MaxWorkers.
+var ErrInvalidWorkers = errors.New("number of workers must be between 1 and MaxWorkers")
+
+// Func is a generic processing function. The input is only valid during the
+// call, as its buffer is reused afterwards, so a Func must not retain it.
+// Results may alias the input, they are copied before the buffer is reused.
 type Func func([]byte) ([]byte, error)
 
-var blobPool = sync.Pool{
-	New: func() any {
-		b := make([]byte, defaultBatchSize, defaultBatchSize)
-		return b
+// ContextFunc is a Func, that additionally receives the context passed to
+// Run, e.g. to honor cancellation or to read request-scoped values.
+type ContextFunc func(ctx context.Context, b []byte) ([]byte, error)
+
+// bufferPool is a pool of batch buffers, counting buffers taken and returned.
+type bufferPool struct {
+	pool sync.Pool
+	gets atomic.Int64
+	puts atomic.Int64
+}
+
+// get returns a buffer of defaultBatchSize bytes.
+func (p *bufferPool) get() []byte {
+	p.gets.Add(1)
+	return p.pool.Get().([]byte)
+}
+
+// put returns a buffer to the pool at its full length, as batches are
+// sliced to their content and would otherwise shorten later batches.
+func (p *bufferPool) put(b []byte) {
+	p.puts.Add(1)
+	p.pool.Put(b[:cap(b)])
+}
+
+var blobPool = &bufferPool{
+	pool: sync.Pool{
+		New: func() any {
+			b := make([]byte, defaultBatchSize, defaultBatchSize)
+			return b
+		},
 	},
 }
 
@@ -41,6 +79,13 @@ func New(r io.Reader, w io.Writer, f Func) *Proc {
 	return proc
 }
 
+// NewContext is like New, but for a context aware processing function.
+func NewContext(r io.Reader, w io.Writer, f ContextFunc) *Proc {
+	proc := New(r, w, nil)
+	proc.cf = f
+	return proc
+}
+
 // Proc wraps a bufio.Scanner and a processing function and will process
 // found tokens in parallel. All output will be written to a given writer.
 type Proc struct {
@@ -50,11 +95,31 @@ type Proc struct {
 	// This may already be a single item or a list of items. In the latter case
 	// it is the task of the processing function to do further parsing
 	f Func
+	// cf is used instead of f, if set.
+	cf ContextFunc
 	// Size is the batch size in bytes, default is 16MB, so with NumCPU number
-	// of threads a 64 core machine would end up using about 1GB of RAM
+	// of threads a 64 core machine would end up using about 1GB of RAM. A
+	// token larger than Size is passed as a batch of its own.
 	Size int
 	// NumWorkers is the number of threads
 	NumWorkers int
+	// ContinueOnError keeps dispatching batches after a worker error, instead
+	// of stopping to read input. Failed batches produce no output, all errors
+	// are still collected, available via Errors and returned from Run.
+	ContinueOnError bool
+	// WriteBeforeError keeps writing results after the context is cancelled,
+	// and processes and writes the batch in progress, before Run returns.
+	// By default, pending results are discarded on cancellation. A
+	// ContextFunc then receives a context, which carries the values of the
+	// context passed to Run, but is not cancelled.
+	WriteBeforeError bool
+	// ResultBuffer is the capacity of the channel between workers and the
+	// writer. By default, the channel is unbuffered, so a worker waits for
+	// the writer with every result. A small buffer smooths out bursts of
+	// results, but each buffered result holds its own copy of a batch
+	// result, so up to ResultBuffer results are kept in memory in addition
+	// to the batches being processed.
+	ResultBuffer int
 
 	// queue is the channel to pass batch of data to a worker
 	queue chan []byte
@@ -71,10 +136,23 @@ type Proc struct {
 	errors []error
 }
 
+// call passes b to the processing function.
+func (p *Proc) call(ctx context.Context, b []byte) ([]byte, error) {
+	if p.cf != nil {
+		return p.cf(ctx, b)
+	}
+	return p.f(b)
+}
+
 // worker can process a blob of bytes with the given Func. If a processing
 // function returns an error this worker will wind down.
 func (p *Proc) worker(ctx context.Context) {
 	defer p.wg.Done()
+	if p.WriteBeforeError {
+		// Every batch handed to a worker is processed and its result sent
+		// to the writer, even after cancellation, until the queue is closed.
+		ctx = context.WithoutCancel(ctx)
+	}
 	for {
 		select {
 		case <-ctx.Done():
@@ -84,11 +162,14 @@ func (p *Proc) worker(ctx context.Context) {
 				return
 			}
 			if ctx.Err() != nil {
-				blobPool.Put(blob)
+				blobPool.put(blob)
 				return
 			}
-			b, err := p.f(blob)
-			r := Result{B: b, Err: err}
+			b, err := p.call(ctx, blob)
+			// The result may alias the blob, which goes back into the pool,
+			// so we keep a right-sized copy only.
+			r := Result{B: bytes.Clone(b), Err: err}
+			blobPool.put(blob)
 			select {
 			case p.resultC <- r:
 				if err != nil {
@@ -97,21 +178,21 @@ func (p *Proc) worker(ctx context.Context) {
 					p.mu.Unlock()
 				}
 			case <-ctx.Done():
-				blobPool.Put(blob)
 				return
 			}
-			blobPool.Put(blob)
 		}
 	}
 }
 
-// writer collects results and writes it to the setup write.
+// writer collects results and writes it to the setup write. Empty results
+// are not written, as some writers, like HTTP chunked responses, would
+// produce an empty write for each.
 func (p *Proc) writer(ctx context.Context) {
 	defer func() {
 		p.done <- true
 	}()
 	for r := range p.resultC {
-		if ctx.Err() != nil || r.Err != nil {
+		if r.Err != nil || len(r.B) == 0 || (ctx.Err() != nil && !p.WriteBeforeError) {
 			continue
 		}
 		_, _ = p.w.Write(r.B)
@@ -124,10 +205,22 @@ func (p *Proc) hasErrors() bool {
 	return len(p.errors) > 0
 }
 
+// Errors returns all errors that occurred during processing so far.
+func (p *Proc) Errors() []error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	errs := make([]error, len(p.errors))
+	copy(errs, p.errors)
+	return errs
+}
+
 // Run start the workers and begins reading and processing data.
 func (p *Proc) Run(ctx context.Context) error {
+	if p.NumWorkers < 1 || p.NumWorkers > MaxWorkers {
+		return ErrInvalidWorkers
+	}
 	p.queue = make(chan []byte)
-	p.resultC = make(chan Result)
+	p.resultC = make(chan Result, max(p.ResultBuffer, 0))
 	p.done = make(chan bool)
 	go p.writer(ctx)
 	p.wg.Add(p.NumWorkers)
@@ -136,14 +229,15 @@ func (p *Proc) Run(ctx context.Context) error {
 	}
 	var (
 		scanner = bufio.NewScanner(p.r)
-		batch   = blobPool.Get().([]byte)
+		batch   = blobPool.get()
 		i       int
 		err     error
 	)
 	for {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			err = ctx.Err()
+			goto cleanup
 		default:
 			if !scanner.Scan() {
 				goto cleanup
@@ -152,10 +246,10 @@ func (p *Proc) Run(ctx context.Context) error {
 				b = scanner.Bytes()
 				k = i + len(b)
 			)
-			if k > len(batch) {
+			if k > min(p.Size, len(batch)) && i > 0 {
 				select {
 				case p.queue <- batch[:i]:
-					batch = blobPool.Get().([]byte)
+					batch = blobPool.get()
 					i = 0
 				case <-ctx.Done():
 					err = ctx.Err()
@@ -164,8 +258,8 @@ func (p *Proc) Run(ctx context.Context) error {
 			}
 			_ = copy(batch[i:], b)
 			i = i + len(b)
-			if p.hasErrors() {
-				err = fmt.Errorf("worker errors: %v", p.errors)
+			if !p.ContinueOnError && p.hasErrors() {
+				err = fmt.Errorf("worker errors: %v", p.Errors())
 				goto cleanup
 			}
 		}
@@ -174,16 +268,40 @@ cleanup:
 	if err == nil {
 		err = scanner.Err()
 	}
-	if i > 0 && batch != nil {
-		p.queue <- batch[:i]
-		batch = nil
+	if err == nil {
+		err = ctx.Err()
+	}
+	// After cancellation, workers may have exited, so the batch in progress
+	// is not queued, but handled here.
+	if i > 0 && ctx.Err() == nil {
+		select {
+		case p.queue <- batch[:i]:
+			batch = nil
+		case <-ctx.Done():
+		}
 	}
 	close(p.queue)
 	p.wg.Wait()
 	close(p.resultC)
 	<-p.done
+	if err == nil {
+		// The context may have been cancelled during processing.
+		err = ctx.Err()
+	}
+	if batch != nil {
+		if i > 0 && p.WriteBeforeError {
+			if b, ferr := p.call(context.WithoutCancel(ctx), batch[:i]); ferr != nil {
+				p.mu.Lock()
+				p.errors = append(p.errors, ferr)
+				p.mu.Unlock()
+			} else if len(b) > 0 {
+				_, _ = p.w.Write(b)
+			}
+		}
+		blobPool.put(batch)
+	}
 	if p.hasErrors() {
-		return fmt.Errorf("worker errors: %v", p.errors)
+		return fmt.Errorf("worker errors: %v", p.Errors())
 	}
 	return err
 }
@@ -5,8 +5,13 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestProc(t *testing.T) {
@@ -88,3 +93,419 @@ func TestProcParallel(t *testing.T) {
 		})
 	}
 }
+
+func TestProcContinueOnError(t *testing.T) {
+	var cases = []struct {
+		name            string
+		continueOnError bool
+		expected        string
+	}{
+		{name: "abort", continueOnError: false, expected: "a"},
+		{name: "continue", continueOnError: true, expected: "acd"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			p := New(strings.NewReader("a\nb\nc\nd\n"), &buf, func(b []byte) ([]byte, error) {
+				if string(b) == "b" {
+					return nil, fmt.Errorf("bad batch")
+				}
+				return bytes.Clone(b), nil
+			})
+			p.Size = 1
+			p.NumWorkers = 1
+			p.ContinueOnError = c.continueOnError
+			if err := p.Run(context.Background()); err == nil {
+				t.Fatalf("got nil, want error")
+			}
+			if len(p.Errors()) != 1 {
+				t.Fatalf("got %d errors, want 1", len(p.Errors()))
+			}
+			if c.continueOnError && buf.String() != c.expected {
+				t.Fatalf("got %v, want %v", buf.String(), c.expected)
+			}
+			if !c.continueOnError && !strings.HasPrefix(buf.String(), c.expected) {
+				t.Fatalf("got %v, want prefix %v", buf.String(), c.expected)
+			}
+		})
+	}
+}
+
+func TestProcInvalidWorkers(t *testing.T) {
+	for _, n := range []int{0, -1, MaxWorkers + 1} {
+		p := New(strings.NewReader("a\n"), io.Discard, func(p []byte) ([]byte, error) {
+			return p, nil
+		})
+		p.NumWorkers = n
+		if err := p.Run(context.Background()); err != ErrInvalidWorkers {
+			t.Errorf("got %v, want %v", err, ErrInvalidWorkers)
+		}
+	}
+}
+
+func TestProcBuffersReturned(t *testing.T) {
+	var (
+		input strings.Builder
+		lines []string
+	)
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&input, "%03d\n", i)
+		lines = append(lines, fmt.Sprintf("%03d", i))
+	}
+	gets, puts := blobPool.gets.Load(), blobPool.puts.Load()
+	var buf bytes.Buffer
+	p := New(strings.NewReader(input.String()), &buf, func(b []byte) ([]byte, error) {
+		// The result aliases the pooled input.
+		return b, nil
+	})
+	p.Size = 3
+	p.NumWorkers = 4
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	// Each batch holds a single three byte token.
+	var result []string
+	for out := buf.String(); len(out) >= 3; out = out[3:] {
+		result = append(result, out[:3])
+	}
+	sort.Strings(result)
+	if !reflect.DeepEqual(result, lines) {
+		t.Fatalf("got %v, want %v", result, lines)
+	}
+	if g, p := blobPool.gets.Load()-gets, blobPool.puts.Load()-puts; g != p {
+		t.Fatalf("got %d buffers taken, %d returned", g, p)
+	}
+}
+
+// cancelReader cancels a context, once its data has been read.
+type cancelReader struct {
+	r      io.Reader
+	cancel context.CancelFunc
+}
+
+func (r *cancelReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		r.cancel()
+	}
+	return n, err
+}
+
+// endlessReader returns lines forever, calling f after each read.
+type endlessReader struct {
+	f func()
+}
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	r.f()
+	for i := range p {
+		p[i] = "ab\n"[i%3]
+	}
+	return len(p) - len(p)%3, nil
+}
+
+func TestProcCancel(t *testing.T) {
+	var cases = []struct {
+		about            string
+		writeBeforeError bool
+		result           string
+	}{
+		{about: `Pending batch is dropped.`, writeBeforeError: false, result: ""},
+		{about: `Pending batch is written.`, writeBeforeError: true, result: "ABC"},
+	}
+	for _, c := range cases {
+		gets, puts := blobPool.gets.Load(), blobPool.puts.Load()
+		ctx, cancel := context.WithCancel(context.Background())
+		var buf bytes.Buffer
+		r := &cancelReader{r: strings.NewReader("a\nb\nc\n"), cancel: cancel}
+		p := New(r, &buf, func(b []byte) ([]byte, error) {
+			return bytes.ToUpper(b), nil
+		})
+		p.WriteBeforeError = c.writeBeforeError
+		if err := p.Run(ctx); err != context.Canceled {
+			t.Fatalf("[%s] got %v, want %v", c.about, err, context.Canceled)
+		}
+		if buf.String() != c.result {
+			t.Errorf("[%s] got %q, want %q", c.about, buf.String(), c.result)
+		}
+		if g, p := blobPool.gets.Load()-gets, blobPool.puts.Load()-puts; g != p {
+			t.Errorf("[%s] got %d buffers taken, %d returned", c.about, g, p)
+		}
+	}
+}
+
+func TestProcCancelMidDispatch(t *testing.T) {
+	for _, wbe := range []bool{false, true} {
+		gets, puts := blobPool.gets.Load(), blobPool.puts.Load()
+		ctx, cancel := context.WithCancel(context.Background())
+		var reads int
+		r := &endlessReader{f: func() {
+			if reads++; reads == 2 {
+				cancel()
+			}
+		}}
+		p := New(r, io.Discard, func(b []byte) ([]byte, error) {
+			time.Sleep(time.Millisecond)
+			return b, nil
+		})
+		p.Size = 16
+		p.NumWorkers = 4
+		p.WriteBeforeError = wbe
+		if err := p.Run(ctx); err != context.Canceled {
+			t.Fatalf("got %v, want %v", err, context.Canceled)
+		}
+		if g, p := blobPool.gets.Load()-gets, blobPool.puts.Load()-puts; g != p {
+			t.Errorf("got %d buffers taken, %d returned", g, p)
+		}
+	}
+}
+
+func TestProcWriteBeforeErrorKeepsResults(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		var (
+			reads     int
+			processed atomic.Int64
+			buf       bytes.Buffer
+		)
+		r := &endlessReader{f: func() {
+			if reads++; reads == 2 {
+				cancel()
+			}
+		}}
+		p := New(r, &buf, func(b []byte) ([]byte, error) {
+			time.Sleep(time.Millisecond)
+			processed.Add(int64(len(b)))
+			return b, nil
+		})
+		p.Size = 16
+		p.NumWorkers = 4
+		p.WriteBeforeError = true
+		if err := p.Run(ctx); err != context.Canceled {
+			t.Fatalf("got %v, want %v", err, context.Canceled)
+		}
+		// Every batch processed is written.
+		if n := processed.Load(); int64(buf.Len()) != n {
+			t.Fatalf("got %d bytes written, want %d processed", buf.Len(), n)
+		}
+	}
+}
+
+func TestProcAliasedResults(t *testing.T) {
+	var (
+		input strings.Builder
+		want  []string
+	)
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&input, "%04d\n", i)
+		want = append(want, fmt.Sprintf("%03d", i))
+	}
+	var buf bytes.Buffer
+	p := New(strings.NewReader(input.String()), &buf, func(b []byte) ([]byte, error) {
+		// A zero-copy filter, returning a sub-slice of the pooled input.
+		return b[1:], nil
+	})
+	p.Size = 4
+	p.NumWorkers = 8
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	var result []string
+	for out := buf.String(); len(out) >= 3; out = out[3:] {
+		result = append(result, out[:3])
+	}
+	sort.Strings(result)
+	sort.Strings(want)
+	if !reflect.DeepEqual(result, want) {
+		t.Fatalf("got %d results, want %d, output corrupted", len(result), len(want))
+	}
+}
+
+// countingWriter counts the number of Write calls.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n++
+	return w.w.Write(p)
+}
+
+func TestProcSkipEmptyResults(t *testing.T) {
+	var cases = []struct {
+		name   string
+		input  string
+		f      Func
+		writes int
+		want   string
+	}{{
+		name:  "all empty",
+		input: "a\nb\nc\n",
+		f: func(b []byte) ([]byte, error) {
+			return nil, nil
+		},
+		writes: 0,
+		want:   "",
+	}, {
+		name:  "some empty",
+		input: "a\nb\na\n",
+		f: func(b []byte) ([]byte, error) {
+			return bytes.ReplaceAll(b, []byte("b"), nil), nil
+		},
+		writes: 2,
+		want:   "aa",
+	}}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := &countingWriter{w: &buf}
+			p := New(strings.NewReader(tt.input), w, tt.f)
+			p.Size = 1
+			p.NumWorkers = 1
+			if err := p.Run(context.Background()); err != nil {
+				t.Fatalf("got %v, want nil", err)
+			}
+			if w.n != tt.writes {
+				t.Errorf("got %d writes, want %d", w.n, tt.writes)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestProcContextFunc(t *testing.T) {
+	type key struct{}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "trace-1"))
+	defer cancel()
+	var (
+		started  = make(chan struct{})
+		observed = make(chan string, 1)
+		once     sync.Once
+	)
+	p := NewContext(strings.NewReader("a\n"), io.Discard, func(ctx context.Context, b []byte) ([]byte, error) {
+		once.Do(func() { close(started) })
+		<-ctx.Done()
+		observed <- ctx.Value(key{}).(string)
+		return nil, nil
+	})
+	p.NumWorkers = 1
+	go func() {
+		<-started
+		cancel()
+	}()
+	if err := p.Run(ctx); err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	select {
+	case v := <-observed:
+		if v != "trace-1" {
+			t.Errorf("got value %q, want %q", v, "trace-1")
+		}
+	default:
+		t.Fatalf("cancellation not observed in transformer")
+	}
+}
+
+func BenchmarkResultBuffer(b *testing.B) {
+	input := strings.Repeat("a line of input\n", 20000)
+	for _, size := range []int{0, 1, 4, 16} {
+		b.Run(fmt.Sprintf("buffer-%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				w := writerFunc(func(p []byte) (int, error) {
+					time.Sleep(time.Millisecond)
+					return len(p), nil
+				})
+				// Every eighth batch takes much longer, so results arrive in
+				// bursts.
+				var n atomic.Int64
+				p := New(strings.NewReader(input), w, func(b []byte) ([]byte, error) {
+					if n.Add(1)%8 == 0 {
+						time.Sleep(20 * time.Millisecond)
+					} else {
+						time.Sleep(time.Millisecond)
+					}
+					return b, nil
+				})
+				p.Size = 4096
+				p.NumWorkers = 4
+				p.ResultBuffer = size
+				if err := p.Run(context.Background()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// writerFunc adapts a function to io.Writer.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func TestBufferPoolFullLength(t *testing.T) {
+	// A short batch returned to the pool must not shorten later batches.
+	blobPool.put(blobPool.get()[:3])
+	for i := 0; i < 4; i++ {
+		b := blobPool.get()
+		if len(b) != defaultBatchSize {
+			t.Fatalf("got buffer of length %d, want %d", len(b), defaultBatchSize)
+		}
+		defer blobPool.put(b)
+	}
+	var (
+		input strings.Builder
+		buf   bytes.Buffer
+	)
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&input, "%03d\n", i)
+	}
+	p := New(strings.NewReader(input.String()), &buf, func(b []byte) ([]byte, error) {
+		return b, nil
+	})
+	p.NumWorkers = 1
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if want := strings.ReplaceAll(input.String(), "\n", ""); buf.String() != want {
+		t.Fatalf("got %d bytes, want %d", buf.Len(), len(want))
+	}
+}
+
+func TestProcSize(t *testing.T) {
+	var cases = []struct {
+		about string
+		size  int
+		want  int
+	}{
+		{about: "default size, single batch", size: defaultBatchSize, want: 90},
+		{about: "two tokens per batch", size: 8, want: 6},
+		{about: "one token per batch", size: 3, want: 3},
+		{about: "token larger than size", size: 1, want: 3},
+	}
+	for _, c := range cases {
+		var (
+			mu      sync.Mutex
+			longest int
+			buf     bytes.Buffer
+		)
+		p := New(strings.NewReader(strings.Repeat("abc\n", 30)), &buf, func(b []byte) ([]byte, error) {
+			mu.Lock()
+			longest = max(longest, len(b))
+			mu.Unlock()
+			return b, nil
+		})
+		p.Size = c.size
+		if err := p.Run(context.Background()); err != nil {
+			t.Fatalf("[%s] got %v, want nil", c.about, err)
+		}
+		if longest != c.want {
+			t.Errorf("[%s] got longest batch %d, want %d", c.about, longest, c.want)
+		}
+		if buf.Len() != 90 {
+			t.Errorf("[%s] got %d bytes, want 90", c.about, buf.Len())
+		}
+	}
+}
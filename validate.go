@@ -0,0 +1,75 @@
+package parallel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// ErrInvalidUTF8 is returned by ValidateUTF8 for records, that are not valid
+// UTF-8.
+var ErrInvalidUTF8 = errors.New("invalid utf-8")
+
+// Validator checks a decoded JSON document, e.g. against a schema. Any JSON
+// schema library can be plugged in with a small adapter.
+type Validator interface {
+	Validate(doc any) error
+}
+
+// ValidatorFunc adapts a function to the Validator interface.
+type ValidatorFunc func(doc any) error
+
+// Validate calls f.
+func (f ValidatorFunc) Validate(doc any) error { return f(doc) }
+
+// RequiredKeys is a simple validator, that requires a document to be an
+// object containing all given keys.
+type RequiredKeys []string
+
+// Validate checks, whether doc is an object with all required keys.
+func (keys RequiredKeys) Validate(doc any) error {
+	m, ok := doc.(map[string]any)
+	if !ok {
+		return fmt.Errorf("expected object, got %T", doc)
+	}
+	for _, k := range keys {
+		if _, ok := m[k]; !ok {
+			return fmt.Errorf("missing key: %s", k)
+		}
+	}
+	return nil
+}
+
+// ValidateJSON returns a transformer for newline delimited JSON, that passes
+// valid records unchanged and returns an error for records, that cannot be
+// parsed or fail validation. Combine it with DeadLetterWriter, MaxErrors or
+// PassthroughOnError to decide what happens with invalid records.
+func ValidateJSON(v Validator) TransformerFunc {
+	return func(b []byte) ([]byte, error) {
+		var doc any
+		if err := json.Unmarshal(b, &doc); err != nil {
+			return nil, err
+		}
+		if err := v.Validate(doc); err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+}
+
+// ValidateUTF8 returns a transformer, that passes records with valid UTF-8
+// to f, and returns ErrInvalidUTF8 for records, that are not valid UTF-8.
+// Combine it with DeadLetterWriter, MaxErrors or PassthroughOnError to decide
+// what happens with invalid records. A nil f passes valid records unchanged.
+func ValidateUTF8(f TransformerFunc) TransformerFunc {
+	return func(b []byte) ([]byte, error) {
+		if !utf8.Valid(b) {
+			return nil, ErrInvalidUTF8
+		}
+		if f == nil {
+			return b, nil
+		}
+		return f(b)
+	}
+}
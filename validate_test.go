@@ -0,0 +1,89 @@
+package parallel
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestValidateJSON(t *testing.T) {
+	var cases = []struct {
+		about string
+		input string
+		valid bool
+	}{
+		{about: `Valid record.`, input: `{"id": 1, "title": "a"}`, valid: true},
+		{about: `Additional keys are fine.`, input: `{"id": 1, "title": "a", "x": 2}`, valid: true},
+		{about: `Missing key.`, input: `{"id": 1}`, valid: false},
+		{about: `Not an object.`, input: `[1, 2]`, valid: false},
+		{about: `Invalid JSON.`, input: `{"id": 1,`, valid: false},
+	}
+	f := ValidateJSON(RequiredKeys{"id", "title"})
+	for _, c := range cases {
+		b, err := f([]byte(c.input))
+		if c.valid && (err != nil || string(b) != c.input) {
+			t.Errorf("[%s] got %q, %v, want %q, nil", c.about, b, err, c.input)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("[%s] got nil, want error", c.about)
+		}
+	}
+	// Invalid records go to the dead letter writer.
+	var out, dead bytes.Buffer
+	var input strings.Builder
+	for _, c := range cases {
+		input.WriteString(c.input + "\n")
+	}
+	p := NewProcessor(strings.NewReader(input.String()), &out, f)
+	p.DeadLetterWriter = &dead
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if n := strings.Count(out.String(), "\n"); n != 2 {
+		t.Errorf("p.Run: got %d valid records, want 2", n)
+	}
+	if n := strings.Count(dead.String(), "\n"); n != 3 {
+		t.Errorf("p.Run: got %d invalid records, want 3", n)
+	}
+}
+
+func TestValidateUTF8(t *testing.T) {
+	var cases = []struct {
+		about string
+		input string
+		valid bool
+	}{
+		{about: `ASCII.`, input: "hello", valid: true},
+		{about: `Multibyte.`, input: "größe 日本", valid: true},
+		{about: `Invalid byte.`, input: "a\xffb", valid: false},
+		{about: `Truncated sequence.`, input: "\xe6\x97", valid: false},
+		{about: `Surrogate half.`, input: "\xed\xa0\x80", valid: false},
+	}
+	f := ValidateUTF8(ToTransformerFunc(bytes.ToUpper))
+	for _, c := range cases {
+		b, err := f([]byte(c.input))
+		if c.valid && (err != nil || string(b) != strings.ToUpper(c.input)) {
+			t.Errorf("[%s] got %q, %v, want %q, nil", c.about, b, err, strings.ToUpper(c.input))
+		}
+		if !c.valid && err != ErrInvalidUTF8 {
+			t.Errorf("[%s] got %v, want %v", c.about, err, ErrInvalidUTF8)
+		}
+	}
+	// Invalid records go to the dead letter writer.
+	var out, dead bytes.Buffer
+	var input strings.Builder
+	for _, c := range cases {
+		input.WriteString(c.input + "\n")
+	}
+	p := NewProcessor(strings.NewReader(input.String()), &out, f)
+	p.DeadLetterWriter = &dead
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	if n := strings.Count(out.String(), "\n"); n != 2 {
+		t.Errorf("p.Run: got %d valid records, want 2", n)
+	}
+	if n := strings.Count(dead.String(), "\n"); n != 3 {
+		t.Errorf("p.Run: got %d invalid records, want 3", n)
+	}
+}
@@ -0,0 +1,82 @@
+package parallel
+
+import (
+	"errors"
+	"io"
+	"runtime"
+)
+
+// ErrInvalidWindow is returned, if window size or step are not positive.
+var ErrInvalidWindow = errors.New("window size and step must be positive")
+
+// WindowFunc transforms a window of consecutive records.
+type WindowFunc func(window [][]byte) ([]byte, error)
+
+// WindowProcessor processes overlapping windows of records in parallel, e.g.
+// for n-gram or context window computations. A window contains Size
+// consecutive records, the first records of two consecutive windows are Step
+// records apart. Each window is passed to F in a worker, so the order of the
+// output is not preserved.
+type WindowProcessor struct {
+	Size            int
+	Step            int
+	RecordSeparator byte
+	NumWorkers      int
+	R               io.Reader
+	W               io.Writer
+	F               WindowFunc
+}
+
+// NewWindowProcessor creates a new window processor, emitting windows of size
+// records every step records.
+func NewWindowProcessor(r io.Reader, w io.Writer, size, step int, f WindowFunc) *WindowProcessor {
+	return &WindowProcessor{
+		Size:            size,
+		Step:            step,
+		RecordSeparator: '\n',
+		NumWorkers:      runtime.NumCPU(),
+		R:               r,
+		W:               w,
+		F:               f,
+	}
+}
+
+// Run reads all records and dispatches windows to the workers. Records keep
+// their separator. Trailing records that do not fill a complete window are
+// not emitted, so an input with fewer than Size records produces no windows.
+func (p *WindowProcessor) Run() error {
+	if p.Size < 1 || p.Step < 1 {
+		return ErrInvalidWindow
+	}
+	return fanout(p.NumWorkers, p.W, p.windows, p.F)
+}
+
+// windows reads records into a sliding buffer and emits complete windows.
+func (p *WindowProcessor) windows(emit func([][]byte) bool) error {
+	var (
+		buf  [][]byte
+		skip int // records to drop, if step is larger than size
+	)
+	return readRecords(p.R, p.RecordSeparator, func(b []byte) bool {
+		if skip > 0 {
+			skip--
+			return true
+		}
+		buf = append(buf, b)
+		if len(buf) < p.Size {
+			return true
+		}
+		window := make([][]byte, len(buf))
+		copy(window, buf)
+		if !emit(window) {
+			return false
+		}
+		if p.Step < len(buf) {
+			buf = buf[p.Step:]
+		} else {
+			skip = p.Step - len(buf)
+			buf = nil
+		}
+		return true
+	})
+}
@@ -0,0 +1,80 @@
+package parallel
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestWindowProcessor(t *testing.T) {
+	var cases = []struct {
+		about    string
+		input    string
+		size     int
+		step     int
+		expected []string
+		err      error
+	}{
+		{
+			about:    `Window of three, step one.`,
+			input:    "1\n2\n3\n4\n5\n",
+			size:     3,
+			step:     1,
+			expected: []string{"1 2 3", "2 3 4", "3 4 5"},
+		},
+		{
+			about:    `Incomplete trailing windows are not emitted.`,
+			input:    "1\n2\n3\n4\n5\n",
+			size:     2,
+			step:     2,
+			expected: []string{"1 2", "3 4"},
+		},
+		{
+			about:    `Step larger than size skips records.`,
+			input:    "1\n2\n3\n4\n5\n6\n7",
+			size:     2,
+			step:     3,
+			expected: []string{"1 2", "4 5"},
+		},
+		{
+			about:    `Too few records for a window.`,
+			input:    "1\n2\n",
+			size:     3,
+			step:     1,
+			expected: nil,
+		},
+		{
+			about: `Invalid window.`,
+			input: "1\n2\n",
+			size:  0,
+			step:  1,
+			err:   ErrInvalidWindow,
+		},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		p := NewWindowProcessor(strings.NewReader(c.input), &buf, c.size, c.step,
+			func(window [][]byte) ([]byte, error) {
+				var fields []string
+				for _, b := range window {
+					fields = append(fields, string(bytes.TrimSpace(b)))
+				}
+				return []byte(strings.Join(fields, " ") + "\n"), nil
+			})
+		if err := p.Run(); err != c.err {
+			t.Fatalf("[%s] got %v, want %v", c.about, err, c.err)
+		}
+		var result []string
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if line != "" {
+				result = append(result, line)
+			}
+		}
+		sort.Strings(result)
+		if !reflect.DeepEqual(result, c.expected) {
+			t.Errorf("[%s] got %v, want %v", c.about, result, c.expected)
+		}
+	}
+}
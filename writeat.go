@@ -0,0 +1,34 @@
+package parallel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrRecordSize is returned, if a result written to a WriteAtSink does not
+// have the configured record size.
+var ErrRecordSize = errors.New("result does not match record size")
+
+// WriteAtSink lets workers write fixed size results directly to their
+// position in the output, bypassing the single writer goroutine. The n-th
+// record, counted after SkipEmptyLines and PreFilter and without preserved
+// comments, is written at offset n*RecordSize of W, e.g. an *os.File, so the
+// output is in input order.
+//
+// Every record must produce a result of exactly RecordSize bytes, otherwise
+// Run fails with ErrRecordSize. W must support concurrent calls to WriteAt
+// for non-overlapping regions, which *os.File does.
+type WriteAtSink struct {
+	W          io.WriterAt
+	RecordSize int
+}
+
+// write writes the result of the n-th record.
+func (s *WriteAtSink) write(n int64, b []byte) error {
+	if len(b) != s.RecordSize {
+		return fmt.Errorf("%w: record %d has %d bytes, want %d", ErrRecordSize, n, len(b), s.RecordSize)
+	}
+	_, err := s.W.WriteAt(b, n*int64(s.RecordSize))
+	return err
+}
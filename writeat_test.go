@@ -0,0 +1,129 @@
+package parallel
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteAtSink(t *testing.T) {
+	var input, expected strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&input, "%d\n", i)
+		fmt.Fprintf(&expected, "%08d\n", i*i)
+	}
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var w bytes.Buffer
+	p := NewProcessor(strings.NewReader(input.String()), &w, func(b []byte) ([]byte, error) {
+		v, err := strconv.Atoi(string(bytes.TrimSpace(b)))
+		if err != nil {
+			return nil, err
+		}
+		return []byte(fmt.Sprintf("%08d\n", v*v)), nil
+	})
+	p.BatchSize = 7
+	p.NumWorkers = 8
+	p.WriteAt = &WriteAtSink{W: f, RecordSize: 9}
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != expected.String() {
+		t.Errorf("got %d bytes, want %d bytes in input order", len(b), expected.Len())
+	}
+	if w.Len() > 0 {
+		t.Errorf("got %q on W, want nothing", w.String())
+	}
+	// Results of the wrong size fail the run.
+	p = NewProcessor(strings.NewReader("1\n22\n"), &w, func(b []byte) ([]byte, error) {
+		return b, nil
+	})
+	p.WriteAt = &WriteAtSink{W: f, RecordSize: 2}
+	if err := p.Run(); !errors.Is(err, ErrRecordSize) {
+		t.Errorf("p.Run: got %v, want %v", err, ErrRecordSize)
+	}
+}
+
+func TestWriteAtSinkCommentsCheckpoint(t *testing.T) {
+	var (
+		dir      = t.TempDir()
+		input    = "1\n# c\n2\n\n3\n"
+		filename = filepath.Join(dir, "checkpoint")
+		w        bytes.Buffer
+	)
+	f, err := os.Create(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p := NewProcessor(strings.NewReader(input), &w, ToTransformerFunc(bytes.ToUpper))
+	p.NumWorkers = 1
+	p.PreserveComments = true
+	p.CheckpointFile = filename
+	p.CheckpointInterval = time.Hour
+	p.WriteAt = &WriteAtSink{W: f, RecordSize: 2}
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	// Comments go to W and leave no holes in the output.
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "1\n2\n3\n" {
+		t.Errorf("got %q, want %q", b, "1\n2\n3\n")
+	}
+	if w.String() != "# c\n\n" {
+		t.Errorf("got %q on W, want %q", w.String(), "# c\n\n")
+	}
+	offset, err := ReadCheckpoint(filename)
+	if err != nil {
+		t.Fatalf("ReadCheckpoint: got %v, want nil", err)
+	}
+	if offset != int64(len(input)) {
+		t.Errorf("ReadCheckpoint: got %d, want %d", offset, len(input))
+	}
+}
+
+func TestWriteAtSinkAux(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var w, aux bytes.Buffer
+	p := NewProcessor(strings.NewReader("a\nb\nc\n"), &w, nil)
+	p.AuxF = func(b []byte) ([]byte, []byte, error) {
+		return bytes.ToUpper(b), b, nil
+	}
+	p.NumWorkers = 1
+	p.AuxWriter = &aux
+	p.WriteAt = &WriteAtSink{W: f, RecordSize: 2}
+	if err := p.Run(); err != nil {
+		t.Fatalf("p.Run: got %v, want nil", err)
+	}
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "A\nB\nC\n" {
+		t.Errorf("got %q, want %q", b, "A\nB\nC\n")
+	}
+	// Auxiliary output is not lost with WriteAt.
+	if aux.String() != "a\nb\nc\n" {
+		t.Errorf("got %q on AuxWriter, want %q", aux.String(), "a\nb\nc\n")
+	}
+}